@@ -2,16 +2,17 @@ package advstreamtools
 
 import (
 	"io"
+	"sync"
 )
 
 // A generalReader is like a reader but it operates on slices of anything,
 // instead of bytes in particular. When specialized to byte this is
 // compatible with io.Reader.
-type GeneralReader[In comparable] interface {
+type GeneralReader[In any] interface {
 	Read([]In) (int, error)
 }
 
-type GeneralReadCloser[In comparable] interface {
+type GeneralReadCloser[In any] interface {
 	GeneralReader[In]
 	io.Closer
 }
@@ -20,31 +21,126 @@ type boundaryState byte
 
 const (
 	baNonMatching = boundaryState(iota)
-	baMatching
 	baYieldingMatch
 	baDrainDueToError
 	baErroring
 )
 
-func NewBoundary[In comparable](src GeneralReader[In], search []In) GeneralReadCloser[In] {
+// A BoundaryReader is the reader returned by NewBoundary and
+// NewBoundaryFunc.
+//
+// Reset discards everything about the stream the reader was reading and
+// sets it to read src, keeping its buffers, so that it can be reused
+// without allocating. It does not close the old stream. If the old stream
+// is an Unreader, such as a PeekReader, the values read from it that have
+// not been returned yet are given back to it, so that it can be read on
+// from where the boundary reader left off.
+type BoundaryReader[In any] interface {
+	GeneralReadCloser[In]
+	Reset(src GeneralReader[In])
+}
+
+// NewBoundary returns a reader that passes src through, doing its best
+// to return each occurrence of search as the entire result of a single
+// Read call, so that whatever is reading it can handle the occurrence
+// without having to put it back together from several Reads.
+//
+// The reader only holds back as much of the stream as the buffer passed
+// to Read can hold, plus any part of a possible match, and its buffers
+// are sized from those, growing only when Read is passed a larger
+// buffer than it has seen before. An empty search never matches.
+func NewBoundary[In comparable](src GeneralReader[In], search []In) BoundaryReader[In] {
+	return NewBoundaryFunc(src, equalityCriteria(search))
+}
+
+func equalityCriteria[In comparable](search []In) []func(In) bool {
+	criteria := make([]func(In) bool, len(search))
+	for idx, want := range search {
+		criteria[idx] = func(val In) bool { return val == want }
+	}
+	return criteria
+}
+
+// NewBoundaryFunc is NewBoundary for a search described by a sequence of
+// predicates rather than of values, so it works on types that are not
+// comparable, and can match values loosely: bytes case-insensitively,
+// events by one of their fields, or any of a class of values.
+//
+// A run of values is a match if each of them satisfies the predicate in
+// the same place in search. As with NewBoundary, a match is returned
+// atomically by a single Read call whenever the buffer can hold it.
+func NewBoundaryFunc[In any](src GeneralReader[In], search []func(In) bool) BoundaryReader[In] {
 	return &boundaryAtomic[In]{
 		r:      src,
 		search: search,
 	}
 }
 
+// A BoundaryPool holds boundary readers for one search,
+// for reuse across streams. A reader keeps the buffers it has grown while
+// in use, so once the pool is warmed up, a high volume of streams can be
+// searched without allocating.
+type BoundaryPool[In any] struct {
+	search []func(In) bool
+	pool   sync.Pool
+}
+
+// NewBoundaryPool returns an empty pool of readers for search.
+func NewBoundaryPool[In comparable](search []In) *BoundaryPool[In] {
+	return NewBoundaryFuncPool(equalityCriteria(search))
+}
+
+// NewBoundaryFuncPool returns an empty pool of the readers NewBoundaryFunc
+// returns for search.
+func NewBoundaryFuncPool[In any](search []func(In) bool) *BoundaryPool[In] {
+	return &BoundaryPool[In]{search: search}
+}
+
+// Get returns a reader from the pool, or a new one if it is empty, set
+// to read src.
+func (bp *BoundaryPool[In]) Get(src GeneralReader[In]) BoundaryReader[In] {
+	ba, _ := bp.pool.Get().(*boundaryAtomic[In])
+	if ba == nil {
+		ba = &boundaryAtomic[In]{search: bp.search, pool: bp}
+	}
+	ba.Reset(src)
+	return ba
+}
+
+// Put puts a reader obtained from Get back into the pool, after which it
+// must not be used. Put does not close the reader, but does Reset it;
+// readers that did not come from this pool are ignored.
+func (bp *BoundaryPool[In]) Put(br BoundaryReader[In]) {
+	ba, isBoundary := br.(*boundaryAtomic[In])
+	if !isBoundary || ba.pool != bp {
+		return
+	}
+	ba.Reset(nil)
+	bp.pool.Put(ba)
+}
+
 // boundaryAtomic tries to return a given search string as an atomic Read value.
-type boundaryAtomic[In comparable] struct {
+type boundaryAtomic[In any] struct {
 	r GeneralReader[In]
 
-	// the sequence of values we are looking for.
-	search []In
-	// the buffer of things we are looking for.
-	badMatchBuf []In
-	currBuf     []In
+	// the predicates the sequence of values we are looking for must
+	// satisfy.
+	search []func(In) bool
 
-	nonMatchingAccum []In
-	matchingAccum    []In
+	// in is the values read from r that have not been passed on yet,
+	// the first matched of which match the start of the search. It is
+	// a view into inBuf, moved back to the start of it before each
+	// read.
+	in      []In
+	inBuf   []In
+	matched int
+
+	// nonMatching is the values known not to be part of a match that
+	// are waiting to be returned.
+	nonMatching RingWindow[In]
+
+	// the pool the reader belongs to, if any.
+	pool *BoundaryPool[In]
 
 	err error
 
@@ -60,113 +156,94 @@ func (ba *boundaryAtomic[In]) Close() error {
 	return nil
 }
 
+// Reset sets the reader to read src from the start.
+func (ba *boundaryAtomic[In]) Reset(src GeneralReader[In]) {
+	if unreader, canUnread := ba.r.(Unreader[In]); canUnread {
+		// Unread puts values in front of any given back before, so
+		// the last values go back first.
+		first, second := ba.nonMatching.Views()
+		unreader.Unread(ba.in)
+		unreader.Unread(second)
+		unreader.Unread(first)
+	}
+	ba.r = src
+	ba.in = ba.inBuf[:0]
+	ba.matched = 0
+	ba.nonMatching.Reset()
+	ba.err = nil
+	ba.state = baNonMatching
+}
+
+// size makes sure the buffers can take a Read into a buffer of size n.
+// They are only ever grown, so a reader that is reused stops allocating
+// once it has seen the largest buffer it will be passed.
+func (ba *boundaryAtomic[In]) size(n int) {
+	// Before a read, in holds less than a whole match.
+	if need := len(ba.search) + n; need > len(ba.inBuf) {
+		inBuf := make([]In, need)
+		ba.in = inBuf[:copy(inBuf, ba.in)]
+		ba.inBuf = inBuf
+	}
+	if n > ba.nonMatching.Cap() {
+		grown := RingWindow[In]{buf: make([]In, n)}
+		first, second := ba.nonMatching.Views()
+		grown.Append(first)
+		grown.Append(second)
+		ba.nonMatching = grown
+	}
+}
+
+// fill moves what is left of in to the start of inBuf, and reads up to n
+// more values after it.
+func (ba *boundaryAtomic[In]) fill(n int) {
+	ba.in = ba.inBuf[:copy(ba.inBuf, ba.in)]
+	read, err := ba.r.Read(ba.inBuf[len(ba.in) : len(ba.in)+n])
+	ba.in = ba.inBuf[:len(ba.in)+read]
+	// errors are supposed to still return what they can, not cut off
+	// the values returned so far, so those are searched before the
+	// error is returned.
+	ba.err = err
+}
+
 // Read will read from the wrapped reader, trying its best to yield the
 // search term as a single read result.
 func (ba *boundaryAtomic[In]) Read(buf []In) (int, error) {
-	// common actions the states perform
-
-	// extend the buffer. If the return value is true, we should
-	// immediately continue, otherwise the state can consider the
-	// buffer extension "done".
-	nextVal := func() (In, bool) {
-		if len(ba.badMatchBuf) > 0 {
-			return pop(&ba.badMatchBuf), false
-		}
-		if len(ba.currBuf) > 0 {
-			return pop(&ba.currBuf), false
-		}
-		// if we're out of stuff to return, and there was an error
-		// on the last read call, transition to the error state
-		if ba.err != nil {
-			var zero In
-			ba.state = baDrainDueToError
-			return zero, true
-		}
-
-		// There is no next value, so we need to try to extend the
-		// buffer first.
-
-		n, err := ba.r.Read(buf)
-		if n == 0 {
-			// readers are supposed to read through this case.
-			ba.err = err
-			var zero In
-			return zero, true
-		}
-		// successfully read a buffer.
-		ba.currBuf = append(ba.currBuf, buf[:n]...)
-		if err != nil {
-			// errors are supposed to still return what they
-			// can, not cut off the values returned so far.
-			// fortunately this is the same behavior for all
-			// states using this.
-			ba.err = err
-		}
-		return pop(&ba.currBuf), false
+	if len(buf) == 0 {
+		return 0, nil
 	}
+	ba.size(len(buf))
 
 StateLoop:
 	for {
 		switch ba.state {
-		// The initial state because we start with a non-matching
-		// state. In this state, the matchingAccum should always be
-		// empty.
+		// The initial state. Values are moved from in to
+		// nonMatching until the values at the start of in match the
+		// whole search.
 		case baNonMatching:
-			// This accounts for the possibility of variable
-			// sized buffers; just because the buffer used to
-			// be larger than this doesn't mean that the next
-			// call's buffer will be.
-			if len(buf) <= len(ba.matchingAccum) {
-				n := move(buf, &ba.matchingAccum)
-				return n, nil
-			}
-
-			if len(ba.matchingAccum) > 0 {
-				panic("in baNonMatching state with non-empty matching accumulation buffer")
-			}
-
-			nextVal, immediatelyContinue := nextVal()
-			if immediatelyContinue {
-				continue StateLoop
-			}
-
-			if nextVal == ba.search[0] {
-				ba.matchingAccum = append(ba.matchingAccum, nextVal)
-				ba.state = baMatching
-				continue StateLoop
-			}
-
-			ba.nonMatchingAccum = append(ba.nonMatchingAccum, nextVal)
-			continue StateLoop
-
-		case baMatching:
-			// FIXME: Push criterion func
-
-			// We have a match. Push out the non-matching stuff
-			// first if any, then push the match.
-			if len(ba.matchingAccum) == len(ba.search) {
+			if len(ba.search) > 0 && ba.matched == len(ba.search) {
 				ba.state = baYieldingMatch
 				continue StateLoop
 			}
 
-			// see if the next thing matches the search
-			// criterion
-			nextValue, immediatelyContinue := nextVal()
-			if immediatelyContinue {
+			if ba.matched == len(ba.in) {
+				if ba.err != nil {
+					ba.state = baDrainDueToError
+					continue StateLoop
+				}
+				// There is no next value, so we need to try to
+				// extend the buffer first.
+				ba.fill(len(buf))
 				continue StateLoop
 			}
 
-			if nextValue == ba.search[len(ba.matchingAccum)] {
-				ba.matchingAccum = append(ba.matchingAccum,
-					nextValue)
+			if len(ba.search) > 0 && ba.search[ba.matched](ba.in[ba.matched]) {
+				ba.matched++
 				continue StateLoop
 			}
 
-			// otherwise, this DOESN'T match. We need to put
-			// the first thing we thought might be a match into
-			// the nonMatchingAccum, and roll the remainder
-			// into the badMatchBuf for a retry on the
-			// matching.
+			// otherwise, this DOESN'T match. The first thing we
+			// thought might be a match is not one, and the
+			// matching is retried from the one after it.
 			//
 			// For types like byte with a very limited number
 			// of values in it, there are more efficient
@@ -176,54 +253,49 @@ StateLoop:
 			// is, honestly, still not bad, especially versus
 			// the competition, which is trying to hold the
 			// entire stream in RAM at once.
-			ba.nonMatchingAccum = append(ba.nonMatchingAccum,
-				ba.matchingAccum[0])
-			ba.badMatchBuf = append(ba.badMatchBuf,
-				ba.matchingAccum[1:]...)
-			ba.badMatchBuf = append(ba.badMatchBuf, nextValue)
-			ba.matchingAccum = ba.matchingAccum[:0]
-			ba.state = baNonMatching
+			if ba.nonMatching.Free() == 0 {
+				// Nothing more will fit; pass on what we have.
+				return ba.nonMatching.Move(buf), nil
+			}
+			ba.nonMatching.Append(ba.in[:1])
+			ba.in = ba.in[1:]
+			ba.matched = 0
 			continue StateLoop
 
 		case baYieldingMatch:
-			if len(ba.badMatchBuf) > 0 {
-				panic("bad match buffer has contents in yield")
-			}
-
 			// If there was any non-matching stuff before this,
 			// yield it.
-			if len(ba.nonMatchingAccum) > 0 {
-				n := move(buf, &ba.nonMatchingAccum)
-				return n, nil
+			if ba.nonMatching.Len() > 0 {
+				return ba.nonMatching.Move(buf), nil
 			}
 
-			// then we clear the match buffer. If this buffer
-			// is too small to handle the match, we return it
-			// as best as we can.
-			if len(ba.matchingAccum) > 0 {
-				n := move(buf, &ba.matchingAccum)
-				return n, nil
+			// then we clear the match. If this buffer is too
+			// small to handle the match, we return it as best
+			// as we can.
+			n := copy(buf, ba.in[:ba.matched])
+			ba.in = ba.in[n:]
+			ba.matched -= n
+			if ba.matched == 0 {
+				// now we've cleared everything, resume the
+				// matching process
+				ba.state = baNonMatching
 			}
-
-			// now we've apparently cleared everything, resume
-			// the matching process
-			ba.state = baNonMatching
-			continue StateLoop
+			return n, nil
 
 		// we have received a read error, but we had more stuff to
 		// yield first. return the stuff from before the error,
 		// prior to returning the error.
 		case baDrainDueToError:
-			if len(ba.matchingAccum) > 0 {
-				panic("in error drain, have stuff in the matching accumulation buffer")
-			}
-			if len(ba.currBuf) > 0 {
-				panic("in error drain, still have stuff in buffer")
-			}
-			// is there anything in the non-matching accumulator?
-			if len(ba.nonMatchingAccum) > 0 {
-				n := move[In](buf, &ba.nonMatchingAccum)
-				return n, nil
+			// If the stream ended part way through what might
+			// have been a match, it wasn't one after all. Nor
+			// can anything after its start be, as that would be
+			// shorter still.
+			n := ba.nonMatching.Move(buf)
+			m := copy(buf[n:], ba.in)
+			ba.in = ba.in[m:]
+			ba.matched = len(ba.in)
+			if n+m > 0 {
+				return n + m, nil
 			}
 
 			// if we reach here, we have finished writing out
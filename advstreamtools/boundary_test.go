@@ -3,7 +3,9 @@ package advstreamtools
 import (
 	"fmt"
 	"io"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -53,16 +55,16 @@ func (cr *ChunkReader) Close() error {
 
 func TestSimpleBoundaryTest(t *testing.T) {
 	for idx, test := range []SimpleBoundaryTest{
-		// FIXME: Handle a null search string correctly
-		// FIXME: This is known broken, but really requires a
-		// pretty significant overhaul of the code to fix and it
-		// does what I need it to in the first place,
-		// so... stopping here for now.
-		// {
-		// 	"ABC",
-		// 	[]string{"01234567890123456789012345678901"},
-		// 	[]string{"01234567890123456789012345678901"},
-		// },
+		{
+			"",
+			[]string{"AB", "C"},
+			[]string{"ABC"},
+		},
+		{
+			"ABC",
+			[]string{"01234567890123456789012345678901"},
+			[]string{"01234567890123456789012345678901"},
+		},
 		{
 			"ABC",
 			[]string{"AB", "A", "", "", "B", "C"},
@@ -138,6 +140,11 @@ func TestSimpleBoundaryTest(t *testing.T) {
 			[]string{"b", "bb", "bbb"},
 			[]string{"bbbbbb"},
 		},
+		{
+			"ABC",
+			[]string{"xxA", "B"},
+			[]string{"xxAB"},
+		},
 	} {
 		for _, lastChunkEOFs := range []bool{true, false} {
 			cr := &ChunkReader{test.In, lastChunkEOFs}
@@ -180,3 +187,158 @@ func TestSimpleBoundaryErrorCases(t *testing.T) {
 	bas = NewBoundary[byte](cr, []byte("abcd"))
 	bas.Close() // coverage
 }
+
+func TestBoundaryFunc(t *testing.T) {
+	// A case-insensitive search for "abc".
+	search := []func(byte) bool{}
+	for _, want := range []byte("abc") {
+		search = append(search, func(b byte) bool {
+			return b == want || b == want-'a'+'A'
+		})
+	}
+	for _, lastChunkEOFs := range []bool{true, false} {
+		cr := &ChunkReader{[]string{"xAb", "Cab", "abC", "aBxabc"}, lastChunkEOFs}
+		bas := NewBoundaryFunc[byte](cr, search)
+		outChunks := []string{}
+		for {
+			buf := make([]byte, 32)
+			n, err := bas.Read(buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			outChunks = append(outChunks, string(buf[:n]))
+		}
+		want := []string{"x", "AbC", "ab", "abC", "aBx", "abc"}
+		if !reflect.DeepEqual(outChunks, want) {
+			spew.Dump(want, outChunks)
+			t.Fatalf("TestBoundaryFunc case %v failed", lastChunkEOFs)
+		}
+	}
+
+	// Events, which are not comparable, matched by a field.
+	type event struct {
+		Kind string
+		Data []int
+	}
+	kind := func(k string) func(event) bool {
+		return func(e event) bool { return e.Kind == k }
+	}
+	events := []event{{"key", nil}, {"open", nil}, {"key", []int{1}}, {"close", nil}}
+	bas := NewBoundaryFunc[event](&sliceReader[event]{events}, []func(event) bool{kind("open"), kind("key"), kind("close")})
+	outChunks := [][]event{}
+	for {
+		buf := make([]event, 8)
+		n, err := bas.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		outChunks = append(outChunks, buf[:n])
+	}
+	if !reflect.DeepEqual(outChunks, [][]event{events[:1], events[1:]}) {
+		spew.Dump(outChunks)
+		t.Fatalf("events not split at the match")
+	}
+}
+
+func TestBoundaryRandom(t *testing.T) {
+	// Whatever the chunks and buffers, the stream must come through
+	// intact, with each match returned whole.
+	rng := rand.New(rand.NewSource(1))
+	for range 1000 {
+		search := []byte("aab")[:1+rng.Intn(3)]
+		text := make([]byte, rng.Intn(200))
+		for idx := range text {
+			text[idx] = "abx"[rng.Intn(3)]
+		}
+		chunks := []string{}
+		for rest := string(text); len(rest) > 0; {
+			n := min(1+rng.Intn(len(search)+4), len(rest))
+			chunks = append(chunks, rest[:n])
+			rest = rest[n:]
+		}
+
+		bas := NewBoundary[byte](&ChunkReader{chunks, rng.Intn(2) == 0}, search)
+		out := []byte{}
+		matches := 0
+		for {
+			buf := make([]byte, len(search)+4+rng.Intn(4))
+			n, err := bas.Read(buf)
+			if string(buf[:n]) == string(search) {
+				matches++
+			}
+			out = append(out, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if string(out) != string(text) {
+			t.Fatalf("%q in %q: read %q", search, chunks, out)
+		}
+		if want := strings.Count(string(text), string(search)); matches != want {
+			t.Fatalf("%q in %q: %d matches, want %d", search, chunks, matches, want)
+		}
+	}
+}
+
+func TestBoundaryPool(t *testing.T) {
+	pool := NewBoundaryPool([]byte("ABC"))
+	text := strings.Repeat("xxABxABCx", 100)
+	src := strings.NewReader(text)
+	buf := make([]byte, 64)
+	readAll := func() {
+		src.Reset(text)
+		bas := pool.Get(src)
+		defer pool.Put(bas)
+		read := 0
+		for {
+			n, err := bas.Read(buf)
+			read += n
+			if err == io.EOF {
+				break
+			}
+		}
+		if read != len(text) {
+			t.Fatalf("read %d bytes, want %d", read, len(text))
+		}
+	}
+
+	readAll()
+	if allocs := testing.AllocsPerRun(100, readAll); allocs != 0 {
+		t.Fatalf("pooled boundary reader made %v allocations", allocs)
+	}
+
+	// Readers that are not the pool's are not taken in.
+	pool.Put(NewBoundary[byte](src, []byte("ABC")))
+	pool.Put(NewBoundaryPool([]byte("ABC")).Get(src))
+}
+
+func TestBoundaryResetUnreads(t *testing.T) {
+	// A boundary reader reads ahead; on Reset, what it read ahead goes
+	// back to a source that can take it.
+	pr := NewPeekReader[byte](&ChunkReader{[]string{"xxA", "BCyy", "zz"}, false})
+	bas := NewBoundary[byte](pr, []byte("ABC"))
+	buf := make([]byte, 32)
+	n, err := bas.Read(buf)
+	if string(buf[:n]) != "xx" || err != nil {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+	bas.Reset(nil)
+
+	rest := []byte{}
+	for {
+		n, err := pr.Read(buf)
+		rest = append(rest, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if string(rest) != "ABCyyzz" {
+		t.Fatalf("left %q", rest)
+	}
+}
@@ -0,0 +1,615 @@
+package advstreamtools
+
+import (
+	"errors"
+	"io"
+	"iter"
+	"slices"
+)
+
+// This file contains a pattern matcher for streams of any comparable
+// type. Patterns are built up like regular expressions, from atoms that
+// each match a single value, and compiled to a program for a pike VM
+// like the one in the streamregexp package, which runs all the ways a
+// pattern could match in lockstep. That takes time linear in the length
+// of the stream, whatever the pattern.
+
+// maxPatternRepeat is the largest count Repeat may be given, the same as
+// for regular expressions.
+const maxPatternRepeat = 1000
+
+// maxPatternProg is the largest number of instructions a compiled
+// pattern may have. Repeats are written out in full, so nested repeats
+// multiply, and without a limit a small pattern could take up any amount
+// of memory; regexp/syntax limits regular expressions the same way.
+const maxPatternProg = 100000
+
+// patternReadSize is the number of values a Matcher reads from its
+// source at a time.
+const patternReadSize = 256
+
+// ErrInvalidRepeat is returned by CompilePattern for a Repeat whose
+// counts are out of order, or larger than 1000.
+var ErrInvalidRepeat = errors.New("advstreamtools: invalid repeat count in pattern")
+
+// ErrPatternTooLarge is returned by CompilePattern for a pattern that
+// compiles to more than 100000 instructions, such as one with repeats
+// nested inside repeats.
+var ErrPatternTooLarge = errors.New("advstreamtools: pattern too large")
+
+type patternOp byte
+
+const (
+	patSeq = patternOp(iota)
+	patAtom
+	patAlt
+	patRepeat
+	patCapture
+)
+
+// A Pattern describes sequences of values to search a stream for. Build
+// one up from Is, Where and AnyValue, which each match a single value,
+// with Seq, Alt, Repeat and Capture, then compile it with CompilePattern.
+//
+// The zero Pattern matches the empty sequence.
+type Pattern[T comparable] struct {
+	op   patternOp
+	pred func(T) bool
+	subs []Pattern[T]
+	min  int
+	max  int
+}
+
+// Is returns a Pattern matching the single value v.
+func Is[T comparable](v T) Pattern[T] {
+	return Where(func(val T) bool { return val == v })
+}
+
+// Where returns a Pattern matching any single value for which pred
+// returns true.
+func Where[T comparable](pred func(T) bool) Pattern[T] {
+	return Pattern[T]{op: patAtom, pred: pred}
+}
+
+// AnyValue returns a Pattern matching any single value.
+func AnyValue[T comparable]() Pattern[T] {
+	return Where(func(T) bool { return true })
+}
+
+// Literal returns a Pattern matching exactly the given values in order.
+func Literal[T comparable](vals ...T) Pattern[T] {
+	subs := make([]Pattern[T], len(vals))
+	for idx, v := range vals {
+		subs[idx] = Is(v)
+	}
+	return Seq(subs...)
+}
+
+// Seq returns a Pattern matching each of the given patterns in turn.
+func Seq[T comparable](ps ...Pattern[T]) Pattern[T] {
+	return Pattern[T]{op: patSeq, subs: ps}
+}
+
+// Alt returns a Pattern matching any one of the given patterns. As in a
+// regular expression, earlier patterns are preferred to later ones. Alt
+// of no patterns matches nothing at all.
+func Alt[T comparable](ps ...Pattern[T]) Pattern[T] {
+	return Pattern[T]{op: patAlt, subs: ps}
+}
+
+// Repeat returns a Pattern matching p at least min times and at most max
+// times, or any number of times if max is -1. It matches as many times
+// as it can, as a greedy repetition in a regular expression does.
+func Repeat[T comparable](p Pattern[T], min, max int) Pattern[T] {
+	return Pattern[T]{op: patRepeat, subs: []Pattern[T]{p}, min: min, max: max}
+}
+
+// Star returns a Pattern matching p any number of times.
+func Star[T comparable](p Pattern[T]) Pattern[T] {
+	return Repeat(p, 0, -1)
+}
+
+// Plus returns a Pattern matching p one or more times.
+func Plus[T comparable](p Pattern[T]) Pattern[T] {
+	return Repeat(p, 1, -1)
+}
+
+// Optional returns a Pattern matching p or nothing, preferring p.
+func Optional[T comparable](p Pattern[T]) Pattern[T] {
+	return Repeat(p, 0, 1)
+}
+
+// Capture returns a Pattern matching p, which records where p matched
+// as a group of the match. Groups are numbered from 1 in the order their
+// Captures appear in the pattern, as a regular expression's parenthesized
+// groups are.
+func Capture[T comparable](p Pattern[T]) Pattern[T] {
+	return Pattern[T]{op: patCapture, subs: []Pattern[T]{p}}
+}
+
+type patternInstOp byte
+
+const (
+	// piAtom matches a value for which pred is true, going on to the
+	// next instruction.
+	piAtom = patternInstOp(iota)
+	// piSplit goes on to both x and y, preferring x.
+	piSplit
+	// piJump goes on to x.
+	piJump
+	// piSave records the position in capture slot x, going on to
+	// the next instruction.
+	piSave
+	piMatch
+	piFail
+)
+
+type patternInst[T comparable] struct {
+	op   patternInstOp
+	pred func(T) bool
+	x, y int
+}
+
+// A Matcher is a compiled Pattern, which searches streams of values for
+// matches of it. A Matcher is safe for concurrent use by multiple
+// goroutines.
+type Matcher[T comparable] struct {
+	prog []patternInst[T]
+	ncap int
+}
+
+// CompilePattern compiles p into a Matcher. It fails only if a Repeat in
+// p has invalid counts, or p is too large.
+func CompilePattern[T comparable](p Pattern[T]) (*Matcher[T], error) {
+	c := &patternCompiler[T]{ngroups: 1}
+	c.emit(patternInst[T]{op: piSave, x: 0})
+	if err := c.compile(p); err != nil {
+		return nil, err
+	}
+	c.emit(patternInst[T]{op: piSave, x: 1})
+	c.emit(patternInst[T]{op: piMatch})
+	return &Matcher[T]{prog: c.prog, ncap: 2 * c.ngroups}, nil
+}
+
+// MustCompilePattern is like CompilePattern but panics if p cannot be
+// compiled.
+func MustCompilePattern[T comparable](p Pattern[T]) *Matcher[T] {
+	m, err := CompilePattern(p)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+type patternCompiler[T comparable] struct {
+	prog    []patternInst[T]
+	ngroups int
+}
+
+func (c *patternCompiler[T]) emit(inst patternInst[T]) int {
+	c.prog = append(c.prog, inst)
+	return len(c.prog) - 1
+}
+
+func (c *patternCompiler[T]) compile(p Pattern[T]) error {
+	if len(c.prog) > maxPatternProg {
+		return ErrPatternTooLarge
+	}
+	switch p.op {
+	case patAtom:
+		c.emit(patternInst[T]{op: piAtom, pred: p.pred})
+
+	case patSeq:
+		for _, sub := range p.subs {
+			if err := c.compile(sub); err != nil {
+				return err
+			}
+		}
+
+	case patAlt:
+		if len(p.subs) == 0 {
+			c.emit(patternInst[T]{op: piFail})
+			return nil
+		}
+		jumps := []int{}
+		for idx, sub := range p.subs {
+			split := -1
+			if idx < len(p.subs)-1 {
+				split = c.emit(patternInst[T]{op: piSplit})
+				c.prog[split].x = len(c.prog)
+			}
+			if err := c.compile(sub); err != nil {
+				return err
+			}
+			if split >= 0 {
+				jumps = append(jumps, c.emit(patternInst[T]{op: piJump}))
+				c.prog[split].y = len(c.prog)
+			}
+		}
+		for _, jump := range jumps {
+			c.prog[jump].x = len(c.prog)
+		}
+
+	case patCapture:
+		group := c.ngroups
+		c.ngroups++
+		c.emit(patternInst[T]{op: piSave, x: 2 * group})
+		if err := c.compile(p.subs[0]); err != nil {
+			return err
+		}
+		c.emit(patternInst[T]{op: piSave, x: 2*group + 1})
+
+	case patRepeat:
+		return c.repeat(p)
+	}
+	return nil
+}
+
+// repeat compiles a Repeat by writing its pattern out once for each
+// time it must match, then once more for each time it may, or in a loop
+// if it may match any number of times.
+func (c *patternCompiler[T]) repeat(p Pattern[T]) error {
+	if p.min < 0 || p.min > maxPatternRepeat || p.max > maxPatternRepeat ||
+		p.max != -1 && p.max < p.min {
+		return ErrInvalidRepeat
+	}
+
+	// Every copy of the pattern has the same groups.
+	firstGroup, ngroups := c.ngroups, c.ngroups
+	sub := func() error {
+		c.ngroups = firstGroup
+		err := c.compile(p.subs[0])
+		ngroups = c.ngroups
+		return err
+	}
+	defer func() { c.ngroups = ngroups }()
+
+	if p.max == 0 {
+		// The pattern is never matched, but its groups still count.
+		mark := len(c.prog)
+		err := sub()
+		c.prog = c.prog[:mark]
+		return err
+	}
+
+	for range p.min {
+		if err := sub(); err != nil {
+			return err
+		}
+	}
+
+	if p.max == -1 {
+		split := c.emit(patternInst[T]{op: piSplit})
+		c.prog[split].x = len(c.prog)
+		if err := sub(); err != nil {
+			return err
+		}
+		c.emit(patternInst[T]{op: piJump, x: split})
+		c.prog[split].y = len(c.prog)
+		return nil
+	}
+
+	splits := []int{}
+	for range p.max - p.min {
+		split := c.emit(patternInst[T]{op: piSplit})
+		c.prog[split].x = len(c.prog)
+		splits = append(splits, split)
+		if err := sub(); err != nil {
+			return err
+		}
+	}
+	for _, split := range splits {
+		c.prog[split].y = len(c.prog)
+	}
+	return nil
+}
+
+// NumGroups returns the number of groups captured by the pattern.
+func (m *Matcher[T]) NumGroups() int {
+	return m.ncap/2 - 1
+}
+
+// A PatternMatch is a match of a Matcher in a stream.
+type PatternMatch[T comparable] struct {
+	// Values holds the values that matched.
+	Values []T
+
+	// Index holds the positions in the stream of the match, and of
+	// each of the pattern's groups, as pairs, as the Index of a
+	// regular expression match does. The positions of a group that
+	// took no part in the match are -1.
+	Index []int
+}
+
+// Group returns the values captured by group k, with group 0 being the
+// whole match, or nil if the group took no part in the match.
+func (pm PatternMatch[T]) Group(k int) []T {
+	if pm.Index[2*k] < 0 {
+		return nil
+	}
+	return pm.Values[pm.Index[2*k]-pm.Index[0] : pm.Index[2*k+1]-pm.Index[0]]
+}
+
+// Match reports whether the stream read from src contains a match of the
+// pattern, reading only as far as it must to find one.
+//
+// If reading src fails with an error other than io.EOF before a match is
+// found, Match returns false with the error.
+func (m *Matcher[T]) Match(src GeneralReader[T]) (bool, error) {
+	pm := newPatternMachine(m, src)
+	if pm.search(0) != nil {
+		return true, nil
+	}
+	if pm.err != io.EOF {
+		return false, pm.err
+	}
+	return false, nil
+}
+
+// FindAll returns an iterator over the successive non-overlapping
+// matches of the pattern in the stream read from src, following the same
+// rules as a regular expression's FindAll, including for empty matches.
+//
+// The stream is read as the iteration goes, and only the values that
+// could still be part of a match are held on to. If reading src fails
+// with an error other than io.EOF, the error is yielded last, after the
+// matches found before it.
+func (m *Matcher[T]) FindAll(src GeneralReader[T]) iter.Seq2[PatternMatch[T], error] {
+	return func(yield func(PatternMatch[T], error) bool) {
+		pm := newPatternMachine(m, src)
+		pos, prevMatchEnd := 0, -1
+		for {
+			a := pm.search(pos)
+			if a == nil {
+				break
+			}
+
+			accept := true
+			atEnd := false
+			if a[1] == pos {
+				// An empty match right after a previous match
+				// is not allowed.
+				accept = a[0] != prevMatchEnd
+				_, ok := pm.at(pos)
+				pos++
+				atEnd = !ok
+			} else {
+				pos = a[1]
+			}
+			prevMatchEnd = a[1]
+
+			if accept {
+				match := PatternMatch[T]{
+					Values: slices.Clone(pm.buf[a[0]-pm.base : a[1]-pm.base]),
+					Index:  slices.Clone(a),
+				}
+				if !yield(match, nil) {
+					return
+				}
+			}
+			if atEnd {
+				break
+			}
+		}
+		if pm.err != nil && pm.err != io.EOF {
+			yield(PatternMatch[T]{}, pm.err)
+		}
+	}
+}
+
+// A patternThread is one way the pattern may be matching, waiting at an
+// atom or a match instruction.
+type patternThread struct {
+	pc  int
+	cap []int
+}
+
+// A patternQueue holds the threads to run at a position, in order of
+// preference. It is a sparse set of pcs, so each instruction is only
+// considered once per position.
+type patternQueue struct {
+	sparse  []int
+	dense   []int
+	threads []patternThread
+}
+
+func newPatternQueue(n int) *patternQueue {
+	return &patternQueue{sparse: make([]int, n)}
+}
+
+func (q *patternQueue) contains(pc int) bool {
+	j := q.sparse[pc]
+	return j < len(q.dense) && q.dense[j] == pc
+}
+
+func (q *patternQueue) clear() {
+	q.dense = q.dense[:0]
+	q.threads = q.threads[:0]
+}
+
+// patternMachine runs a Matcher over a stream, holding the values it
+// has read in buf, which starts at position base in the stream.
+type patternMachine[T comparable] struct {
+	m   *Matcher[T]
+	src GeneralReader[T]
+	buf []T
+	// base is the position in the stream of buf[0].
+	base int
+	// keep is the earliest position whose value may still be needed.
+	keep int
+	// err is the error the source returned, once it has returned one.
+	err error
+
+	q0, q1 *patternQueue
+	// scratch is the captures of the thread being added, and matchcap
+	// those of the match found by search.
+	scratch, matchcap []int
+	// capPool holds the capture slices of threads that have died, to
+	// be used again by new ones.
+	capPool [][]int
+}
+
+func newPatternMachine[T comparable](m *Matcher[T], src GeneralReader[T]) *patternMachine[T] {
+	return &patternMachine[T]{
+		m:   m,
+		src: src,
+		q0:  newPatternQueue(len(m.prog)),
+		q1:  newPatternQueue(len(m.prog)),
+
+		scratch:  make([]int, m.ncap),
+		matchcap: make([]int, m.ncap),
+	}
+}
+
+// allocCap returns a capture slice for a new thread, from the pool if
+// there is one there.
+func (pm *patternMachine[T]) allocCap() []int {
+	if n := len(pm.capPool); n > 0 {
+		cap := pm.capPool[n-1]
+		pm.capPool = pm.capPool[:n-1]
+		return cap
+	}
+	return make([]int, pm.m.ncap)
+}
+
+// clear clears q, putting the capture slices of its threads back in the
+// pool.
+func (pm *patternMachine[T]) clear(q *patternQueue) {
+	for _, t := range q.threads {
+		pm.capPool = append(pm.capPool, t.cap)
+	}
+	q.clear()
+}
+
+// at returns the value at pos, reading from the source if need be. It
+// returns false if the stream ends before pos.
+func (pm *patternMachine[T]) at(pos int) (T, bool) {
+	for pos >= pm.base+len(pm.buf) {
+		if !pm.fill() {
+			var zero T
+			return zero, false
+		}
+	}
+	return pm.buf[pos-pm.base], true
+}
+
+// fill reads more values into the buffer, first dropping the ones before
+// keep. It returns false if there are no more to be had.
+func (pm *patternMachine[T]) fill() bool {
+	for empty := 0; pm.err == nil; empty++ {
+		if drop := pm.keep - pm.base; drop > 0 {
+			pm.buf = pm.buf[:copy(pm.buf, pm.buf[drop:])]
+			pm.base += drop
+		}
+		pm.buf = slices.Grow(pm.buf, patternReadSize)
+		n, err := pm.src.Read(pm.buf[len(pm.buf):cap(pm.buf)])
+		pm.buf = pm.buf[:len(pm.buf)+n]
+		pm.err = err
+		if n > 0 {
+			return true
+		}
+		if empty == 100 {
+			pm.err = io.ErrNoProgress
+		}
+	}
+	return false
+}
+
+// search returns the capture positions of the leftmost match of the
+// pattern in the stream at or after pos, or nil if there is none. They
+// are only valid until the next search.
+func (pm *patternMachine[T]) search(pos int) []int {
+	prog := pm.m.prog
+	clist, nlist := pm.q0, pm.q1
+	pm.clear(clist)
+	matched := false
+	for {
+		// Until there is a match, a match may also start here.
+		if !matched {
+			for j := range pm.scratch {
+				pm.scratch[j] = -1
+			}
+			pm.add(clist, 0, pos, pm.scratch)
+		}
+		if len(clist.threads) == 0 {
+			break
+		}
+
+		pm.keep = pos
+		if matched {
+			pm.keep = pm.matchcap[0]
+		}
+		for _, t := range clist.threads {
+			pm.keep = min(pm.keep, t.cap[0])
+		}
+
+		// The value at pos is only read if a thread needs it, so a
+		// match at the end of what has been read so far is found
+		// without waiting on the source.
+		var v T
+		read, more := false, false
+	Threads:
+		for _, t := range clist.threads {
+			inst := &prog[t.pc]
+			switch inst.op {
+			case piMatch:
+				copy(pm.matchcap, t.cap)
+				matched = true
+				// Threads after this one are less preferred.
+				break Threads
+			case piAtom:
+				if !read {
+					v, more = pm.at(pos)
+					read = true
+				}
+				if more && inst.pred(v) {
+					pm.add(nlist, t.pc+1, pos+1, t.cap)
+				}
+			}
+		}
+		// The threads that went on have copies of their captures.
+		pm.clear(clist)
+		if read && !more {
+			break
+		}
+
+		clist, nlist = nlist, clist
+		pos++
+	}
+	pm.clear(clist)
+	pm.clear(nlist)
+	if !matched {
+		return nil
+	}
+	return pm.matchcap
+}
+
+// add adds the thread at pc to q, following jumps, splits and saves to
+// the atom and match instructions they lead to. cap is only changed for
+// the duration of the call; the threads added get copies of it.
+func (pm *patternMachine[T]) add(q *patternQueue, pc int, pos int, cap []int) {
+	if q.contains(pc) {
+		return
+	}
+	q.sparse[pc] = len(q.dense)
+	q.dense = append(q.dense, pc)
+
+	inst := &pm.m.prog[pc]
+	switch inst.op {
+	case piSplit:
+		pm.add(q, inst.x, pos, cap)
+		pm.add(q, inst.y, pos, cap)
+	case piJump:
+		pm.add(q, inst.x, pos, cap)
+	case piSave:
+		old := cap[inst.x]
+		cap[inst.x] = pos
+		pm.add(q, pc+1, pos, cap)
+		cap[inst.x] = old
+	case piAtom, piMatch:
+		t := patternThread{pc, pm.allocCap()}
+		copy(t.cap, cap)
+		q.threads = append(q.threads, t)
+	case piFail:
+	}
+}
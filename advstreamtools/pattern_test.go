@@ -0,0 +1,208 @@
+package advstreamtools
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type PatternTest struct {
+	Regexp  string
+	Pattern Pattern[byte]
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+var patternTests = []PatternTest{
+	{`abc`, Literal[byte]('a', 'b', 'c')},
+	{`a+`, Plus(Is[byte]('a'))},
+	{`a*`, Star(Is[byte]('a'))},
+	{`(a|ab)(c|bcd)(d*)`, Seq(
+		Capture(Alt(Literal[byte]('a'), Literal[byte]('a', 'b'))),
+		Capture(Alt(Literal[byte]('c'), Literal[byte]('b', 'c', 'd'))),
+		Capture(Star(Is[byte]('d'))),
+	)},
+	{`(\d+)-(\d+)?`, Seq(
+		Capture(Plus(Where(isDigit))),
+		Is[byte]('-'),
+		Optional(Capture(Plus(Where(isDigit)))),
+	)},
+	{`(?s)a.{2,4}?`, Seq(Is[byte]('a'), Repeat(AnyValue[byte](), 2, 2))},
+	{`(?s)a.{2,4}`, Seq(Is[byte]('a'), Repeat(AnyValue[byte](), 2, 4))},
+	{`(x(y)?)*z`, Seq(Star(Capture(Seq(Is[byte]('x'), Optional(Capture(Is[byte]('y')))))), Is[byte]('z'))},
+	{`(?:a{0}(b))?c`, Seq(Optional(Seq(Repeat(Is[byte]('a'), 0, 0), Capture(Is[byte]('b')))), Is[byte]('c'))},
+	{``, Pattern[byte]{}},
+	{`[^\x00-\xff]`, Alt[byte]()},
+}
+
+var patternTexts = []string{
+	"",
+	"abc",
+	"aaa abcd abbcd",
+	"12-34 5- -6 xyxz z xxz",
+	"bcbc abc xyyz",
+	strings.Repeat("a", 1000) + "b" + strings.Repeat("0123-", 100),
+}
+
+func TestPatternFindAll(t *testing.T) {
+	for _, test := range patternTests {
+		re := regexp.MustCompile(test.Regexp)
+		m := MustCompilePattern(test.Pattern)
+		if m.NumGroups() != re.NumSubexp() {
+			t.Fatalf("%#q: %d groups, want %d", test.Regexp, m.NumGroups(), re.NumSubexp())
+		}
+		for _, text := range patternTexts {
+			want := re.FindAllStringSubmatchIndex(text, -1)
+			have := [][]int{}
+			src := iotest.OneByteReader(strings.NewReader(text))
+			for match, err := range m.FindAll(src) {
+				if err != nil {
+					t.Fatalf("%#q %q: unexpected error: %v", test.Regexp, text, err)
+				}
+				if string(match.Values) != text[match.Index[0]:match.Index[1]] ||
+					string(match.Group(0)) != string(match.Values) {
+					t.Fatalf("%#q %q: wrong values %q for %v", test.Regexp, text, match.Values, match.Index)
+				}
+				have = append(have, match.Index)
+			}
+			if len(want) == 0 && len(have) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("%#q %q: found %v, want %v", test.Regexp, text, have, want)
+			}
+
+			matched, err := m.Match(strings.NewReader(text))
+			if err != nil || matched != re.MatchString(text) {
+				t.Errorf("%#q %q: Match = %v, %v", test.Regexp, text, matched, err)
+			}
+		}
+	}
+}
+
+type token struct {
+	kind  string
+	value string
+}
+
+func TestPatternTokens(t *testing.T) {
+	kind := func(k string) Pattern[token] {
+		return Where(func(tok token) bool { return tok.kind == k })
+	}
+	// An assignment of a name to a dotted path.
+	m := MustCompilePattern(Seq(
+		Capture(kind("name")),
+		Is(token{"op", "="}),
+		Capture(Seq(kind("name"), Star(Seq(Is(token{"op", "."}), kind("name"))))),
+	))
+
+	tokens := []token{
+		{"name", "x"}, {"op", "="}, {"name", "a"}, {"op", "."}, {"name", "b"},
+		{"op", ";"},
+		{"name", "y"}, {"op", "="}, {"number", "1"},
+		{"name", "z"}, {"op", "="}, {"name", "c"}, {"op", "."},
+	}
+	src := &sliceReader[token]{tokens}
+	matches := [][]token{}
+	for match, err := range m.FindAll(src) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		matches = append(matches, match.Group(1), match.Group(2))
+	}
+	want := [][]token{
+		tokens[0:1], tokens[2:5],
+		tokens[9:10], tokens[11:12],
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Fatalf("found %v, want %v", matches, want)
+	}
+}
+
+// sliceReader is a GeneralReader returning its values one at a time.
+type sliceReader[T any] struct {
+	vals []T
+}
+
+func (sr *sliceReader[T]) Read(buf []T) (int, error) {
+	if len(sr.vals) == 0 {
+		return 0, io.EOF
+	}
+	buf[0] = pop(&sr.vals)
+	return 1, nil
+}
+
+func TestPatternErrors(t *testing.T) {
+	for _, p := range []Pattern[byte]{
+		Repeat(Is[byte]('a'), 2, 1),
+		Repeat(Is[byte]('a'), -1, 1),
+		Repeat(Is[byte]('a'), 0, 1001),
+		Seq(Is[byte]('a'), Star(Repeat(Is[byte]('a'), 5, 4))),
+	} {
+		if _, err := CompilePattern(p); err != ErrInvalidRepeat {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Nested repeats multiply; a million instructions is too many.
+	for _, p := range []Pattern[byte]{
+		Repeat(Repeat(Is[byte]('a'), 1000, 1000), 1000, 1000),
+		Repeat(Repeat(Is[byte]('a'), 0, 1000), 0, 1000),
+		Plus(Repeat(Seq(Repeat(AnyValue[byte](), 100, 100), Is[byte]('a')), 1000, 1000)),
+	} {
+		if _, err := CompilePattern(p); err != ErrPatternTooLarge {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := CompilePattern(Repeat(Repeat(Is[byte]('a'), 10, 10), 1000, 1000)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	failure := errors.New("failure")
+	m := MustCompilePattern(Plus(Is[byte]('b')))
+	src := io.MultiReader(strings.NewReader("abbc bb"), iotest.ErrReader(failure))
+	found := []string{}
+	for match, err := range m.FindAll(src) {
+		if err != nil {
+			if err != failure {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			found = append(found, "error")
+			continue
+		}
+		found = append(found, string(match.Values))
+	}
+	if !reflect.DeepEqual(found, []string{"bb", "bb", "error"}) {
+		t.Fatalf("found %q", found)
+	}
+
+	src = io.MultiReader(strings.NewReader("aaa"), iotest.ErrReader(failure))
+	if matched, err := m.Match(src); matched || err != failure {
+		t.Fatalf("Match = %v, %v", matched, err)
+	}
+}
+
+func TestPatternFindAllAllocs(t *testing.T) {
+	// Searching more of a stream without matches allocates nothing
+	// more: the captures of threads that die are used again.
+	m := MustCompilePattern(Seq(Capture(Plus(Is[byte]('a'))), Optional(Capture(Is[byte]('c'))), Is[byte]('b')))
+	allocs := func(size int) float64 {
+		text := strings.Repeat("aaac ", size/5)
+		src := strings.NewReader(text)
+		return testing.AllocsPerRun(10, func() {
+			src.Reset(text)
+			for range m.FindAll(src) {
+				t.Fatalf("unexpected match")
+			}
+		})
+	}
+	if short, long := allocs(1<<12), allocs(1<<16); long != short {
+		t.Fatalf("%v allocations for a short stream, %v for a long one", short, long)
+	}
+}
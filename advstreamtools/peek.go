@@ -0,0 +1,99 @@
+package advstreamtools
+
+import (
+	"io"
+	"slices"
+)
+
+// An Unreader is a GeneralReader that can be given values back, which it
+// will return again before anything else. The functions in this package
+// and streamtools that read up to a delimiter use Unread, when it is
+// available, to read in bulk and give back whatever they read past the
+// delimiter, and a boundary reader that is Reset gives back what it had
+// read ahead.
+type Unreader[In any] interface {
+	GeneralReader[In]
+	Unread([]In)
+}
+
+// PeekReader wraps a GeneralReader with a buffer that lets values be
+// looked at before they are read, with Peek, and given back after they
+// have been read, with Unread, as many of them as needed.
+//
+// A Read returns values from the buffer if there are any, and otherwise
+// reads straight from the source. An error from the source is held until
+// the values read along with it have been returned.
+type PeekReader[In any] struct {
+	r GeneralReader[In]
+	// buf holds the values peeked at or given back, in the order
+	// they will be read.
+	buf []In
+	err error
+}
+
+// NewPeekReader returns a new PeekReader reading from src.
+func NewPeekReader[In any](src GeneralReader[In]) *PeekReader[In] {
+	return &PeekReader[In]{r: src}
+}
+
+// Read implements GeneralReader.
+func (pr *PeekReader[In]) Read(buf []In) (int, error) {
+	if len(pr.buf) > 0 {
+		return move(buf, &pr.buf), nil
+	}
+	if pr.err != nil {
+		return 0, pr.err
+	}
+	n, err := pr.r.Read(buf)
+	pr.err = err
+	if n > 0 {
+		return n, nil
+	}
+	return 0, err
+}
+
+// Peek returns the next n values without reading them. If there are
+// fewer than n values left before the source fails or ends, it returns
+// those that there are, along with the error. The returned slice is only
+// valid until the next call to the PeekReader.
+func (pr *PeekReader[In]) Peek(n int) ([]In, error) {
+	empty := 0
+	for len(pr.buf) < n && pr.err == nil {
+		pr.buf = slices.Grow(pr.buf, n-len(pr.buf))
+		m, err := pr.r.Read(pr.buf[len(pr.buf):cap(pr.buf)])
+		pr.buf = pr.buf[:len(pr.buf)+m]
+		pr.err = err
+		if m == 0 {
+			empty++
+			if empty == 100 {
+				pr.err = io.ErrNoProgress
+			}
+		}
+	}
+	if len(pr.buf) < n {
+		return pr.buf, pr.err
+	}
+	return pr.buf[:n], nil
+}
+
+// Unread gives vals back to the PeekReader, to be read again before
+// anything else. The values are copied, so vals may be reused. They need
+// not be values that were read from the PeekReader.
+func (pr *PeekReader[In]) Unread(vals []In) {
+	pr.buf = slices.Insert(pr.buf, 0, vals...)
+}
+
+// Buffered returns the number of values that can be read without reading
+// from the source.
+func (pr *PeekReader[In]) Buffered() int {
+	return len(pr.buf)
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (pr *PeekReader[In]) Close() error {
+	closer, isCloser := pr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package advstreamtools
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestPeekReader(t *testing.T) {
+	failure := errors.New("failure")
+	pr := NewPeekReader[int](&errSliceReader{sliceReader[int]{[]int{1, 2, 3, 4, 5}}, failure})
+
+	peeked, err := pr.Peek(3)
+	if err != nil || !reflect.DeepEqual(peeked, []int{1, 2, 3}) || pr.Buffered() != 3 {
+		t.Fatalf("Peek = %v, %v", peeked, err)
+	}
+
+	buf := make([]int, 2)
+	n, err := pr.Read(buf)
+	if n != 2 || err != nil || !reflect.DeepEqual(buf, []int{1, 2}) {
+		t.Fatalf("Read = %v, %v", buf[:n], err)
+	}
+
+	// Anything can be given back, in any amount.
+	pr.Unread([]int{2})
+	pr.Unread([]int{8, 9})
+	all := []int{}
+	for {
+		n, err := pr.Read(buf)
+		all = append(all, buf[:n]...)
+		if err != nil {
+			if err != failure {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			break
+		}
+	}
+	if !reflect.DeepEqual(all, []int{8, 9, 2, 3, 4, 5}) {
+		t.Fatalf("read %v", all)
+	}
+
+	// The error stays, but values given back come first.
+	pr.Unread([]int{7})
+	peeked, err = pr.Peek(2)
+	if err != failure || !reflect.DeepEqual(peeked, []int{7}) {
+		t.Fatalf("Peek = %v, %v", peeked, err)
+	}
+	if pr.Close() != nil {
+		t.Fatalf("unexpected error from Close")
+	}
+}
+
+func TestReadUntilSequenceUnreader(t *testing.T) {
+	pr := NewPeekReader[int](&sliceReader[int]{[]int{1, 2, 3, 9, 9, 4, 9, 9, 5}})
+	buf := make([]int, 5)
+
+	// The delimiter is cut off by the end of buf, so it is given back
+	// and found by the next call.
+	n, done, err := ReadUntilSequence[int](pr, []int{9, 9}, buf[:4])
+	if n != 3 || done || err != nil {
+		t.Fatalf("got %v, %v, %v", buf[:n], done, err)
+	}
+	n, done, err = ReadUntilSequence[int](pr, []int{9, 9}, buf)
+	if n != 0 || !done || err != nil {
+		t.Fatalf("got %v, %v, %v", buf[:n], done, err)
+	}
+	n, done, err = ReadUntilSequence[int](pr, []int{9, 9}, buf)
+	if n != 1 || !done || err != nil || buf[0] != 4 {
+		t.Fatalf("got %v, %v, %v", buf[:n], done, err)
+	}
+	n, done, err = ReadUntilSequence[int](pr, []int{9, 9}, buf)
+	if n != 1 || !done || err != io.EOF || buf[0] != 5 {
+		t.Fatalf("got %v, %v, %v", buf[:n], done, err)
+	}
+}
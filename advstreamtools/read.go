@@ -0,0 +1,148 @@
+package advstreamtools
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrSequenceSplit is returned by ReadUntilSequence when buf fills up
+// part way through what may be the delimiter, on a reader that cannot be
+// given the values back. Those values have been consumed; they are left
+// in buf after the ones returned, and are the start of the delimiter.
+// Reading through a SequenceReader avoids this.
+var ErrSequenceSplit = errors.New("advstreamtools: buffer filled part way through a possible delimiter")
+
+// ReadUntilSequence reads values from r into buf until the sequence delim
+// has been read. It returns the number of values put into buf, which
+// does not include the delimiter, and whether the read completed, which
+// is false if buf filled up first and there is more to read yet. Any
+// error from r is returned, with the read counting as complete.
+//
+// The delimiter is consumed, but nothing after it is. If r is an
+// Unreader, such as a PeekReader, it is read in bulk and given back what
+// was read past the delimiter; otherwise it is read one value at a time.
+//
+// If buf fills up part way through what may turn out to be the
+// delimiter, an Unreader is given those values back, to be checked again
+// by the next call; if buf holds nothing but them, io.ErrShortBuffer is
+// returned. Other readers cannot be given them back, so ErrSequenceSplit
+// is returned. To read a stream a delimited section at a time whatever
+// the size of the sections, use a SequenceReader.
+//
+// If delim is empty, this reads until buf is full or r runs out.
+func ReadUntilSequence[In comparable](r GeneralReader[In], delim []In, buf []In) (int, bool, error) {
+	return readUntilSequence(r, delim, sequenceFailures(delim), buf)
+}
+
+// sequenceFailures returns the failure table for delim: fail[k] is how
+// much of the delimiter is still matched when a match of k+1 values of
+// it goes wrong, as in Knuth-Morris-Pratt.
+func sequenceFailures[In comparable](delim []In) []int {
+	fail := make([]int, len(delim))
+	for k, matched := 1, 0; k < len(delim); k++ {
+		for matched > 0 && delim[k] != delim[matched] {
+			matched = fail[matched-1]
+		}
+		if delim[k] == delim[matched] {
+			matched++
+		}
+		fail[k] = matched
+	}
+	return fail
+}
+
+func readUntilSequence[In comparable](r GeneralReader[In], delim []In, fail []int, buf []In) (int, bool, error) {
+	unreader, canUnread := r.(Unreader[In])
+	mybuf := make([]In, 1)
+	matched := 0
+	idx := 0
+	for idx < len(buf) {
+		readBuf := mybuf
+		if canUnread {
+			readBuf = buf[idx:]
+		}
+		n, err := r.Read(readBuf)
+		for j, v := range readBuf[:n] {
+			if len(delim) == 0 {
+				break
+			}
+			for matched > 0 && v != delim[matched] {
+				matched = fail[matched-1]
+			}
+			if v == delim[matched] {
+				matched++
+			}
+			if matched == len(delim) {
+				if canUnread {
+					unreader.Unread(readBuf[j+1 : n])
+				}
+				return idx + j + 1 - len(delim), true, nil
+			}
+		}
+		if !canUnread && n == 1 {
+			buf[idx] = mybuf[0]
+		}
+		idx += n
+		if err != nil {
+			return idx, true, err
+		}
+	}
+
+	switch {
+	case matched == 0:
+		return len(buf), false, nil
+	case !canUnread:
+		return len(buf) - matched, false, ErrSequenceSplit
+	}
+	unreader.Unread(buf[len(buf)-matched:])
+	if matched == len(buf) {
+		return 0, false, io.ErrShortBuffer
+	}
+	return len(buf) - matched, false, nil
+}
+
+// A SequenceReader reads a stream a delimited section at a time, such as
+// the headers of a series of HTTP-style messages read from a socket. It
+// holds on to what it has read past a delimiter, and to what may be the
+// start of one when the buffer fills up, so the delimiter is found
+// however the stream is split up. For the same reason, once a stream is
+// being read through a SequenceReader, it must only be read through it.
+type SequenceReader[In comparable] struct {
+	r     *PeekReader[In]
+	delim []In
+	fail  []int
+}
+
+// NewSequenceReader returns a SequenceReader reading sections of src
+// delimited by delim.
+func NewSequenceReader[In comparable](src GeneralReader[In], delim []In) *SequenceReader[In] {
+	pr, isPeekReader := src.(*PeekReader[In])
+	if !isPeekReader {
+		pr = NewPeekReader(src)
+	}
+	return &SequenceReader[In]{
+		r:     pr,
+		delim: delim,
+		fail:  sequenceFailures(delim),
+	}
+}
+
+// ReadUntil reads values into buf until the delimiter has been read, and
+// returns as ReadUntilSequence does. If buf fills up part way through
+// what may be the delimiter, those values are kept for the next call, so
+// buf must have room for more than that; if it does not,
+// io.ErrShortBuffer is returned.
+func (sr *SequenceReader[In]) ReadUntil(buf []In) (int, bool, error) {
+	return readUntilSequence(sr.r, sr.delim, sr.fail, buf)
+}
+
+// Read implements GeneralReader, reading the stream without looking for
+// the delimiter, such as to read a message body after its headers.
+func (sr *SequenceReader[In]) Read(buf []In) (int, error) {
+	return sr.r.Read(buf)
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (sr *SequenceReader[In]) Close() error {
+	return sr.r.Close()
+}
@@ -0,0 +1,52 @@
+package advstreamtools
+
+import (
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestReadUntilSequence(t *testing.T) {
+	src := &sliceReader[int]{[]int{1, 2, 1, 2, 1, 3, 4, 5}}
+	buf := make([]int, 8)
+	n, done, err := ReadUntilSequence[int](src, []int{1, 2, 1, 3}, buf)
+	if n != 2 || !done || err != nil || buf[0] != 1 || buf[1] != 2 {
+		t.Fatalf("got %v, %v, %v", buf[:n], done, err)
+	}
+	n, done, err = ReadUntilSequence[int](src, []int{9}, buf)
+	if n != 2 || !done || err != io.EOF || buf[0] != 4 || buf[1] != 5 {
+		t.Fatalf("got %v, %v, %v", buf[:n], done, err)
+	}
+}
+
+func TestSequenceReader(t *testing.T) {
+	// Sections longer than the buffer, with delimiters split by the
+	// end of it, and a buffer too short for what may be the delimiter.
+	src := &sliceReader[int]{[]int{1, 2, 3, 9, 9, 4, 9, 9, 9, 5}}
+	sr := NewSequenceReader[int](src, []int{9, 9, 9})
+	buf := make([]int, 4)
+	got := []int{}
+	for {
+		n, done, err := sr.ReadUntil(buf)
+		got = append(got, buf[:n]...)
+		if done {
+			got = append(got, -1)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if done && n == 0 {
+			break
+		}
+		if done {
+			continue
+		}
+		if _, _, err := sr.ReadUntil(buf[:2]); err != io.ErrShortBuffer {
+			t.Fatalf("short buffer: %v", err)
+		}
+	}
+	rest, _ := sr.Read(buf)
+	if want := []int{1, 2, 3, 9, 9, 4, -1}; !slices.Equal(got, want) || rest != 1 || buf[0] != 5 {
+		t.Fatalf("got %v and %v", got, buf[:rest])
+	}
+}
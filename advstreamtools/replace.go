@@ -0,0 +1,271 @@
+package advstreamtools
+
+import (
+	"io"
+)
+
+// A Replacement is a sequence to search for in a stream, along with what
+// to replace it with.
+type Replacement[In comparable] struct {
+	Search  []In
+	Replace []In
+}
+
+// NewReplacingReader returns a reader that passes src through with every
+// occurrence of each Replacement's Search replaced by its Replace,
+// streaming everything else as it comes.
+//
+// As with strings.Replacer, replacements are made in the order the
+// searches occur in the stream, without overlapping, and where several
+// searches match at the same place, the first of them in replacements
+// wins. The result of a replacement is never searched again. Empty
+// searches are ignored.
+//
+// The searches are all looked for at once, with an Aho-Corasick
+// automaton, so the time taken does not depend on how many there are.
+// Replacements longer than the buffer passed to Read are returned across
+// several Read calls. Only as much of the stream as the longest search is
+// held back at any one time.
+func NewReplacingReader[In comparable](src GeneralReader[In], replacements []Replacement[In]) GeneralReadCloser[In] {
+	rr := &replacingReader[In]{
+		r:     src,
+		nodes: []replaceNode[In]{{out: -1}},
+	}
+	for _, rep := range replacements {
+		if len(rep.Search) > 0 {
+			rr.add(rep)
+		}
+	}
+	rr.link()
+	rr.restart()
+	return rr
+}
+
+// A replaceNode is a node of the automaton: a trie of the searches, with
+// each node also linked to the node for the longest proper suffix of its
+// prefix that is a prefix of some search.
+type replaceNode[In comparable] struct {
+	next  map[In]int
+	fail  int
+	depth int
+	// out is the index of the longest search that ends at this node,
+	// including by way of its fail links, or -1 if there is none.
+	// Only the longest matters, as it starts the earliest.
+	out int
+}
+
+// A replaceMatch is a match found in in, which is to be replaced unless
+// one that starts earlier, or as early and is earlier in the
+// replacements, is found before it is certain.
+type replaceMatch struct {
+	start, end int
+	rep        int
+}
+
+type replacingReader[In comparable] struct {
+	r            GeneralReader[In]
+	replacements []Replacement[In]
+	maxSearch    int
+	nodes        []replaceNode[In]
+
+	// in is what has been read from r that has not been passed on,
+	// the first scan values of which have been run through the
+	// automaton, leaving it at state. It is a view into inBuf, moved
+	// back to the start of it before each read.
+	in    []In
+	inBuf []In
+	scan  int
+	state int
+	// safe is how many values at the start of in cannot be part of a
+	// match, and are ready to be returned.
+	safe int
+	// best is the leftmost match found so far, if found is set. Once
+	// no match can be found that would take its place, it is certain.
+	best    replaceMatch
+	found   bool
+	certain bool
+	// rep is what is left to return of a replacement.
+	rep []In
+
+	err error
+}
+
+// add adds a search to the trie.
+func (rr *replacingReader[In]) add(rep Replacement[In]) {
+	idx := len(rr.replacements)
+	rr.replacements = append(rr.replacements, rep)
+	rr.maxSearch = max(rr.maxSearch, len(rep.Search))
+
+	node := 0
+	for _, v := range rep.Search {
+		next, exists := rr.nodes[node].next[v]
+		if !exists {
+			next = len(rr.nodes)
+			rr.nodes = append(rr.nodes, replaceNode[In]{
+				depth: rr.nodes[node].depth + 1,
+				out:   -1,
+			})
+			if rr.nodes[node].next == nil {
+				rr.nodes[node].next = map[In]int{}
+			}
+			rr.nodes[node].next[v] = next
+		}
+		node = next
+	}
+	// Where the same search is given twice, the first wins.
+	if rr.nodes[node].out < 0 {
+		rr.nodes[node].out = idx
+	}
+}
+
+// link sets the fail links, breadth first, so that each node's fail
+// link is already set when its children are linked.
+func (rr *replacingReader[In]) link() {
+	queue := []int{0}
+	for len(queue) > 0 {
+		node := pop(&queue)
+		for v, child := range rr.nodes[node].next {
+			fail := 0
+			if node != 0 {
+				fail = rr.step(rr.nodes[node].fail, v)
+			}
+			rr.nodes[child].fail = fail
+			if rr.nodes[child].out < 0 {
+				rr.nodes[child].out = rr.nodes[fail].out
+			}
+			queue = append(queue, child)
+		}
+	}
+}
+
+// step returns the state the automaton goes to from node on v.
+func (rr *replacingReader[In]) step(node int, v In) int {
+	for {
+		if next, exists := rr.nodes[node].next[v]; exists {
+			return next
+		}
+		if node == 0 {
+			return 0
+		}
+		node = rr.nodes[node].fail
+	}
+}
+
+// restart starts the automaton over at the start of in.
+func (rr *replacingReader[In]) restart() {
+	rr.scan = 0
+	rr.state = 0
+	rr.found = false
+	rr.certain = false
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (rr *replacingReader[In]) Close() error {
+	closer, isCloser := rr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Read will read from the wrapped reader, replacing the searches in what
+// it reads.
+func (rr *replacingReader[In]) Read(buf []In) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for {
+		if len(rr.rep) > 0 {
+			return move(buf, &rr.rep), nil
+		}
+		if rr.safe > 0 {
+			n := copy(buf, rr.in[:rr.safe])
+			rr.consume(n)
+			return n, nil
+		}
+		if rr.certain && rr.best.start == 0 {
+			// Everything before the match has been returned.
+			rr.rep = rr.replacements[rr.best.rep].Replace
+			rr.consume(rr.best.end)
+			rr.restart()
+			continue
+		}
+
+		if rr.scan < len(rr.in) {
+			rr.advance()
+			continue
+		}
+		if rr.err != nil {
+			// Nothing that has been read can be part of a
+			// match that is still to be found.
+			if !rr.found {
+				rr.safe = len(rr.in)
+			}
+			rr.commit()
+			if len(rr.in) == 0 {
+				return 0, rr.err
+			}
+			continue
+		}
+		rr.fill(len(buf))
+	}
+}
+
+// advance runs the next value through the automaton.
+func (rr *replacingReader[In]) advance() {
+	rr.state = rr.step(rr.state, rr.in[rr.scan])
+	rr.scan++
+	node := &rr.nodes[rr.state]
+	if node.out >= 0 {
+		m := replaceMatch{
+			start: rr.scan - len(rr.replacements[node.out].Search),
+			end:   rr.scan,
+			rep:   node.out,
+		}
+		if !rr.found || m.start < rr.best.start ||
+			m.start == rr.best.start && m.rep < rr.best.rep {
+			rr.best, rr.found = m, true
+		}
+	}
+
+	// No match can start before the prefix the automaton is in.
+	live := rr.scan - node.depth
+	if rr.found && live > rr.best.start {
+		rr.commit()
+	} else if !rr.found {
+		rr.safe = live
+	}
+}
+
+// commit makes the best match found certain, if there is one, so that
+// what comes before it is returned, then its replacement.
+func (rr *replacingReader[In]) commit() {
+	if rr.found {
+		rr.safe = rr.best.start
+		rr.certain = true
+	}
+}
+
+// consume drops the first n values of in.
+func (rr *replacingReader[In]) consume(n int) {
+	rr.in = rr.in[n:]
+	rr.safe -= n
+	rr.scan -= n
+	rr.best.start -= n
+	rr.best.end -= n
+}
+
+// fill moves what is left of in to the start of inBuf, and reads after
+// it. in never holds more than the longest search when it is filled, so
+// there is always room to read at least n values.
+func (rr *replacingReader[In]) fill(n int) {
+	if need := rr.maxSearch + n; need > len(rr.inBuf) {
+		inBuf := make([]In, need)
+		rr.in = inBuf[:copy(inBuf, rr.in)]
+		rr.inBuf = inBuf
+	}
+	rr.in = rr.inBuf[:copy(rr.inBuf, rr.in)]
+	read, err := rr.r.Read(rr.inBuf[len(rr.in):])
+	rr.in = rr.inBuf[:len(rr.in)+read]
+	rr.err = err
+}
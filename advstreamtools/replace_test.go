@@ -0,0 +1,169 @@
+package advstreamtools
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReplacingReader(t *testing.T) {
+	reps := []Replacement[byte]{
+		{[]byte("ab"), []byte("X")},
+		{[]byte("abc"), []byte("never, as ab comes first")},
+		{[]byte("bcd"), []byte("")},
+		{[]byte("d"), []byte("DDDDDDDDDD")},
+		{nil, []byte("ignored")},
+	}
+	for _, test := range []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"abc", "Xc"},
+		{"xbcdy", "xy"},
+		{"aabcd abd", "aXcDDDDDDDDDD XDDDDDDDDDD"},
+		{"ab" + strings.Repeat("-", 1000) + "ab", "X" + strings.Repeat("-", 1000) + "X"},
+	} {
+		for _, size := range []int{1, 3, 64} {
+			rr := NewReplacingReader[byte](iotest.OneByteReader(strings.NewReader(test.in)), reps)
+			out := []byte{}
+			for {
+				buf := make([]byte, size)
+				n, err := rr.Read(buf)
+				out = append(out, buf[:n]...)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if string(out) != test.out {
+				t.Errorf("%q with buffer %d: got %q, want %q", test.in, size, out, test.out)
+			}
+		}
+	}
+}
+
+func TestReplacingReaderValues(t *testing.T) {
+	// Replacing runs of values in a stream of ints, which ends in an
+	// error.
+	failure := errors.New("failure")
+	src := &errSliceReader{sliceReader[int]{[]int{1, 2, 3, 1, 2, 1}}, failure}
+	rr := NewReplacingReader[int](src, []Replacement[int]{{[]int{1, 2}, []int{0}}})
+	out := []int{}
+	var err error
+	for err == nil {
+		buf := make([]int, 4)
+		var n int
+		n, err = rr.Read(buf)
+		out = append(out, buf[:n]...)
+	}
+	if err != failure || !reflect.DeepEqual(out, []int{0, 3, 0, 1}) {
+		t.Fatalf("got %v, %v", out, err)
+	}
+	if rr.Close() != nil {
+		t.Fatalf("unexpected error from Close")
+	}
+}
+
+func TestReplacingReaderEmptyBuffer(t *testing.T) {
+	rr := NewReplacingReader[int](&errSliceReader{sliceReader[int]{[]int{1}}, io.EOF},
+		[]Replacement[int]{{[]int{1}, []int{2}}})
+	if n, err := rr.Read(nil); n != 0 || err != nil {
+		t.Fatalf("Read of an empty buffer = %d, %v", n, err)
+	}
+	buf := make([]int, 1)
+	if n, err := rr.Read(buf); n != 1 || err != nil || buf[0] != 2 {
+		t.Fatalf("Read = %d, %v, %v", n, err, buf)
+	}
+	if n, err := rr.Read(nil); n != 0 || err != nil {
+		t.Fatalf("Read of an empty buffer at the end = %d, %v", n, err)
+	}
+	if n, err := rr.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read at the end = %d, %v", n, err)
+	}
+}
+
+func TestReplacingReaderRandom(t *testing.T) {
+	// Whatever the searches, chunks and buffers, the output must be
+	// the same as replacing the text all in one go.
+	rng := rand.New(rand.NewSource(1))
+	randomString := func(n int) string {
+		b := make([]byte, n)
+		for idx := range b {
+			b[idx] = "abc"[rng.Intn(3)]
+		}
+		return string(b)
+	}
+	for range 1000 {
+		reps := []Replacement[byte]{}
+		for range 1 + rng.Intn(5) {
+			reps = append(reps, Replacement[byte]{
+				[]byte(randomString(rng.Intn(5))),
+				[]byte(strings.ToUpper(randomString(rng.Intn(12)))),
+			})
+		}
+		text := randomString(rng.Intn(100))
+		chunks := []string{}
+		for rest := text; len(rest) > 0; {
+			n := min(1+rng.Intn(8), len(rest))
+			chunks = append(chunks, rest[:n])
+			rest = rest[n:]
+		}
+
+		rr := NewReplacingReader[byte](&ChunkReader{chunks, rng.Intn(2) == 0}, reps)
+		out := []byte{}
+		for {
+			buf := make([]byte, 8+rng.Intn(4))
+			n, err := rr.Read(buf)
+			out = append(out, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if want := replaceAll(text, reps); string(out) != want {
+			t.Fatalf("%q with %q: got %q, want %q", text, reps, out, want)
+		}
+	}
+}
+
+// replaceAll replaces the searches in text the simple way, trying each
+// search in turn at each position.
+func replaceAll(text string, reps []Replacement[byte]) string {
+	var out strings.Builder
+	i := 0
+outer:
+	for i < len(text) {
+		for _, rep := range reps {
+			if len(rep.Search) > 0 && strings.HasPrefix(text[i:], string(rep.Search)) {
+				out.Write(rep.Replace)
+				i += len(rep.Search)
+				continue outer
+			}
+		}
+		out.WriteByte(text[i])
+		i++
+	}
+	return out.String()
+}
+
+// errSliceReader is a sliceReader that ends with the given error.
+type errSliceReader struct {
+	sliceReader[int]
+	err error
+}
+
+func (esr *errSliceReader) Read(buf []int) (int, error) {
+	n, err := esr.sliceReader.Read(buf)
+	if err == io.EOF {
+		err = esr.err
+	}
+	return n, err
+}
@@ -0,0 +1,195 @@
+package advstreamtools
+
+import (
+	"io"
+)
+
+// RingWindow is a window over the most recent values of a stream, held
+// in a ring buffer of fixed capacity, so that keeping values as they
+// come in and dropping them as they are used up never allocates.
+//
+// Its contents are read through views: pairs of slices into the ring,
+// holding the values in order when the first is followed by the second.
+// Views are only valid until the window is next changed.
+//
+// The methods that consume values mirror the slice helpers in this
+// package: Advance drops values from the front, Move copies them out and
+// drops them, and Pop takes one.
+//
+// The zero RingWindow has no capacity; use NewRingWindow.
+type RingWindow[T any] struct {
+	buf []T
+	// start is the index in buf of the oldest value, and n the number
+	// of values held.
+	start int
+	n     int
+}
+
+// NewRingWindow returns an empty RingWindow that holds up to capacity
+// values.
+func NewRingWindow[T any](capacity int) *RingWindow[T] {
+	return &RingWindow[T]{buf: make([]T, capacity)}
+}
+
+// Len returns the number of values in the window.
+func (rw *RingWindow[T]) Len() int {
+	return rw.n
+}
+
+// Cap returns the number of values the window can hold.
+func (rw *RingWindow[T]) Cap() int {
+	return len(rw.buf)
+}
+
+// Free returns the number of values that can be added to the window
+// before it is full.
+func (rw *RingWindow[T]) Free() int {
+	return len(rw.buf) - rw.n
+}
+
+// Reset empties the window.
+func (rw *RingWindow[T]) Reset() {
+	rw.start = 0
+	rw.n = 0
+}
+
+// index returns the index in buf of the ith value in the window.
+func (rw *RingWindow[T]) index(i int) int {
+	idx := rw.start + i
+	if idx >= len(rw.buf) {
+		idx -= len(rw.buf)
+	}
+	return idx
+}
+
+// At returns the ith value in the window, counting from the oldest. It
+// panics if i is out of range.
+func (rw *RingWindow[T]) At(i int) T {
+	if i < 0 || i >= rw.n {
+		panic("advstreamtools: RingWindow index out of range")
+	}
+	return rw.buf[rw.index(i)]
+}
+
+// Views returns the contents of the window, oldest first, as the values
+// of first followed by those of second. Either may be empty.
+func (rw *RingWindow[T]) Views() (first, second []T) {
+	return rw.views(rw.start, rw.n)
+}
+
+// Last returns views of the most recent n values in the window, or of
+// all of them if there are fewer. This is the lookbehind a stream
+// algorithm has on what it has already passed over.
+func (rw *RingWindow[T]) Last(n int) (first, second []T) {
+	n = min(max(n, 0), rw.n)
+	return rw.views(rw.index(rw.n-n), n)
+}
+
+// views returns the n values starting at index start in buf.
+func (rw *RingWindow[T]) views(start, n int) (first, second []T) {
+	end := start + n
+	if end <= len(rw.buf) {
+		return rw.buf[start:end], nil
+	}
+	return rw.buf[start:], rw.buf[:end-len(rw.buf)]
+}
+
+// freeView returns the first contiguous stretch of free space after the
+// values in the window.
+func (rw *RingWindow[T]) freeView() []T {
+	if rw.n == len(rw.buf) {
+		return nil
+	}
+	end := rw.index(rw.n)
+	if end < rw.start {
+		return rw.buf[end:rw.start]
+	}
+	return rw.buf[end:]
+}
+
+// Append adds as many of vals to the window as it has room for, returning
+// how many it added.
+func (rw *RingWindow[T]) Append(vals []T) int {
+	added := 0
+	for len(vals) > 0 && rw.n < len(rw.buf) {
+		n := copy(rw.freeView(), vals)
+		vals = vals[n:]
+		rw.n += n
+		added += n
+	}
+	return added
+}
+
+// Slide adds all of vals to the window, dropping the oldest values as
+// needed to make room, so that the window holds the most recent values
+// of the stream.
+func (rw *RingWindow[T]) Slide(vals []T) {
+	if len(rw.buf) == 0 {
+		return
+	}
+	if len(vals) >= len(rw.buf) {
+		copy(rw.buf, vals[len(vals)-len(rw.buf):])
+		rw.start = 0
+		rw.n = len(rw.buf)
+		return
+	}
+	rw.Advance(max(len(vals)-rw.Free(), 0))
+	rw.Append(vals)
+}
+
+// Fill reads from r straight into the window's free space, with a
+// single Read call, returning what the call returned. If the window is
+// full, it returns 0 without reading.
+func (rw *RingWindow[T]) Fill(r GeneralReader[T]) (int, error) {
+	free := rw.freeView()
+	if len(free) == 0 {
+		return 0, nil
+	}
+	n, err := r.Read(free)
+	rw.n += n
+	return n, err
+}
+
+// Advance drops the oldest n values from the window. As with the slice
+// helper advance, n must not exceed the length of the window.
+func (rw *RingWindow[T]) Advance(n int) {
+	if n > rw.n {
+		panic("advstreamtools: RingWindow advanced past its end")
+	}
+	if n == rw.n {
+		// Start over at the beginning, as advance does, so the
+		// free space is in one piece.
+		rw.Reset()
+		return
+	}
+	rw.start = rw.index(n)
+	rw.n -= n
+}
+
+// Move copies as many of the oldest values in the window as fit into
+// dst, and drops them from the window, returning how many it moved.
+func (rw *RingWindow[T]) Move(dst []T) int {
+	first, second := rw.Views()
+	n := copy(dst, first)
+	n += copy(dst[n:], second)
+	rw.Advance(n)
+	return n
+}
+
+// Pop removes and returns the oldest value in the window. Like the slice
+// helper pop, it does no length checking, and so panics if the window is
+// empty.
+func (rw *RingWindow[T]) Pop() T {
+	val := rw.At(0)
+	rw.Advance(1)
+	return val
+}
+
+// Read implements GeneralReader, moving values out of the window. It
+// returns io.EOF once the window is empty.
+func (rw *RingWindow[T]) Read(buf []T) (int, error) {
+	if rw.n == 0 && len(buf) > 0 {
+		return 0, io.EOF
+	}
+	return rw.Move(buf), nil
+}
@@ -0,0 +1,139 @@
+package advstreamtools
+
+import (
+	"io"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// contents returns the values in the window, through its views.
+func contents[T any](rw *RingWindow[T]) []T {
+	first, second := rw.Views()
+	return append(slices.Clone(first), second...)
+}
+
+func TestRingWindow(t *testing.T) {
+	rw := NewRingWindow[int](5)
+	if n := rw.Append([]int{1, 2, 3}); n != 3 || rw.Len() != 3 || rw.Free() != 2 {
+		t.Fatalf("Append added %d", n)
+	}
+	if rw.Pop() != 1 || rw.Pop() != 2 {
+		t.Fatalf("Pop returned the wrong values")
+	}
+
+	// This wraps around the end of the ring.
+	if n := rw.Append([]int{4, 5, 6, 7, 8}); n != 4 {
+		t.Fatalf("Append added %d", n)
+	}
+	first, second := rw.Views()
+	if !reflect.DeepEqual(first, []int{3, 4, 5}) || !reflect.DeepEqual(second, []int{6, 7}) {
+		t.Fatalf("Views = %v, %v", first, second)
+	}
+	first, second = rw.Last(3)
+	if !reflect.DeepEqual(append(slices.Clone(first), second...), []int{5, 6, 7}) {
+		t.Fatalf("Last = %v, %v", first, second)
+	}
+	if rw.At(4) != 7 {
+		t.Fatalf("At(4) = %d", rw.At(4))
+	}
+
+	rw.Slide([]int{8, 9})
+	if have := contents(rw); !reflect.DeepEqual(have, []int{5, 6, 7, 8, 9}) {
+		t.Fatalf("after Slide, have %v", have)
+	}
+	rw.Slide([]int{1, 2, 3, 4, 5, 6, 7})
+	if have := contents(rw); !reflect.DeepEqual(have, []int{3, 4, 5, 6, 7}) {
+		t.Fatalf("after Slide, have %v", have)
+	}
+
+	buf := make([]int, 4)
+	if n := rw.Move(buf); n != 4 || !reflect.DeepEqual(buf, []int{3, 4, 5, 6}) {
+		t.Fatalf("Move = %v", buf[:n])
+	}
+	if n, err := rw.Read(buf); n != 1 || err != nil || buf[0] != 7 {
+		t.Fatalf("Read = %v, %v", buf[:n], err)
+	}
+	if n, err := rw.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read = %v, %v", buf[:n], err)
+	}
+
+	// Once emptied, the free space is in one piece again.
+	src := &sliceReader[int]{[]int{10, 11, 12, 13, 14, 15}}
+	for rw.Free() > 0 {
+		if _, err := rw.Fill(src); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if first, second := rw.Views(); len(first) != 5 || len(second) != 0 {
+		t.Fatalf("Views = %v, %v", first, second)
+	}
+	if n, err := rw.Fill(src); n != 0 || err != nil {
+		t.Fatalf("Fill a full window = %d, %v", n, err)
+	}
+}
+
+func TestRingWindowRandom(t *testing.T) {
+	// The window must always hold what a plain slice would.
+	rng := rand.New(rand.NewSource(1))
+	rw := NewRingWindow[int](7)
+	model := []int{}
+	next := 0
+	for range 10000 {
+		vals := make([]int, rng.Intn(10))
+		for idx := range vals {
+			vals[idx] = next
+			next++
+		}
+		switch rng.Intn(4) {
+		case 0:
+			n := rw.Append(vals)
+			model = append(model, vals[:n]...)
+		case 1:
+			rw.Slide(vals)
+			model = append(model, vals...)
+			model = model[max(len(model)-7, 0):]
+		case 2:
+			n := rng.Intn(len(model) + 1)
+			rw.Advance(n)
+			model = model[n:]
+		case 3:
+			buf := make([]int, rng.Intn(10))
+			n := rw.Move(buf)
+			if !reflect.DeepEqual(buf[:n], model[:n]) {
+				t.Fatalf("Move = %v, want %v", buf[:n], model[:n])
+			}
+			model = model[n:]
+		}
+		if have := contents(rw); !reflect.DeepEqual(have, model) && len(model) > 0 {
+			t.Fatalf("window holds %v, want %v", have, model)
+		}
+		if rw.Len() != len(model) {
+			t.Fatalf("window has %d values, want %d", rw.Len(), len(model))
+		}
+	}
+}
+
+func BenchmarkRingWindow(b *testing.B) {
+	vals := make([]byte, 100)
+	buf := make([]byte, 64)
+	b.Run("RingWindow", func(b *testing.B) {
+		b.ReportAllocs()
+		rw := NewRingWindow[byte](4096)
+		for i := 0; i < b.N; i++ {
+			rw.Append(vals)
+			rw.Move(buf)
+			rw.Advance(min(rw.Len(), 30))
+		}
+	})
+	b.Run("Append", func(b *testing.B) {
+		b.ReportAllocs()
+		var s []byte
+		for i := 0; i < b.N; i++ {
+			s = append(s, vals...)
+			move(buf, &s)
+			advance(&s, min(len(s), 30))
+		}
+	})
+}
@@ -2,6 +2,8 @@ package streamtools
 
 import (
 	"io"
+	"unicode"
+	"unicode/utf8"
 
 	_ "github.com/davecgh/go-spew/spew"
 	"github.com/thejerf/streamtools/advstreamtools"
@@ -27,3 +29,198 @@ func NewBoundaryString(src io.Reader, search string) io.Reader {
 func NewBoundaryStringCloser(src io.ReadCloser, search string) io.ReadCloser {
 	return advstreamtools.NewBoundary[byte](src, []byte(search))
 }
+
+// BoundaryStringPool is a pool of the readers NewBoundaryStringCloser
+// returns for one search string, which can be reused to search many
+// streams, such as the bodies of requests to a busy proxy, without
+// allocating once the pool is warmed up. Get returns a reader for a
+// stream, which should be given back with Put when done with.
+//
+// See advstreamtools.BoundaryPool.
+type BoundaryStringPool = advstreamtools.BoundaryPool[byte]
+
+// NewBoundaryStringPool returns an empty BoundaryStringPool for search.
+func NewBoundaryStringPool(search string) *BoundaryStringPool {
+	return advstreamtools.NewBoundaryPool([]byte(search))
+}
+
+// CaseFolding selects how NewBoundaryStringFolded compares the stream to
+// its search string.
+type CaseFolding int
+
+const (
+	// CaseSensitive matches only the exact bytes of the search string,
+	// as NewBoundaryString does.
+	CaseSensitive = CaseFolding(iota)
+
+	// FoldASCII matches ASCII letters regardless of their case. All
+	// other bytes must match exactly.
+	FoldASCII
+
+	// FoldUnicode matches runes that are equal under Unicode simple
+	// case folding, as strings.EqualFold does. A match may then be a
+	// different number of bytes than the search string; "k" matches
+	// the three-byte Kelvin sign, for instance.
+	FoldUnicode
+)
+
+// NewBoundaryStringFolded is NewBoundaryStringCloser, matching the search
+// string with the given case folding, so that a search for "password"
+// can also catch "Password" and "PASSWORD". The bytes of a match are
+// returned as they were in the stream, atomically in a single Read call
+// as long as the buffer can hold them, even when a rune of the match was
+// split across reads of the source.
+//
+// With FoldUnicode, invalid UTF-8 in the stream never matches. The
+// returned reader will close src if it is an io.Closer.
+func NewBoundaryStringFolded(src io.Reader, search string, folding CaseFolding) io.ReadCloser {
+	switch folding {
+	case FoldASCII:
+		criteria := make([]func(byte) bool, len(search))
+		for idx := range len(search) {
+			want := lowerASCII(search[idx])
+			criteria[idx] = func(b byte) bool { return lowerASCII(b) == want }
+		}
+		return advstreamtools.NewBoundaryFunc[byte](src, criteria)
+
+	case FoldUnicode:
+		criteria := []func(runeUnit) bool{}
+		for _, want := range search {
+			criteria = append(criteria, func(u runeUnit) bool {
+				return u.size > 0 && foldsTo(u.r, want)
+			})
+		}
+		return &unitBoundary{
+			units: advstreamtools.NewBoundaryFunc[runeUnit](&runeUnitReader{r: src}, criteria),
+		}
+
+	default:
+		return advstreamtools.NewBoundary[byte](src, []byte(search))
+	}
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// foldsTo reports whether r is equal to want under simple case folding.
+func foldsTo(r, want rune) bool {
+	if r == want {
+		return true
+	}
+	for f := unicode.SimpleFold(want); f != want; f = unicode.SimpleFold(f) {
+		if f == r {
+			return true
+		}
+	}
+	return false
+}
+
+// A runeUnit is a rune of a stream along with the bytes it was encoded
+// as. An invalid byte is a runeUnit of its own, with a negative size.
+type runeUnit struct {
+	r     rune
+	bytes [utf8.UTFMax]byte
+	size  int8
+}
+
+// runeUnitReader reads a byte stream as a stream of runeUnits, holding
+// on to any rune split across reads of the source until it is whole.
+type runeUnitReader struct {
+	r io.Reader
+	// raw is the bytes read that have not been turned into units yet.
+	// It is always a window into buf.
+	raw []byte
+	buf []byte
+	err error
+}
+
+// Read implements advstreamtools.GeneralReader.
+func (ur *runeUnitReader) Read(units []runeUnit) (int, error) {
+	n := 0
+	for n < len(units) {
+		if len(ur.raw) == 0 || !utf8.FullRune(ur.raw) && ur.err == nil {
+			if n > 0 {
+				break
+			}
+			if ur.err != nil {
+				return 0, ur.err
+			}
+			ur.fill()
+			continue
+		}
+
+		r, size := utf8.DecodeRune(ur.raw)
+		u := runeUnit{r: r, size: int8(size)}
+		copy(u.bytes[:], ur.raw[:size])
+		if r == utf8.RuneError && size == 1 {
+			u.size = -1
+		}
+		units[n] = u
+		n++
+		ur.raw = ur.raw[size:]
+	}
+	return n, nil
+}
+
+// fill moves what is left of raw, which is less than a whole rune, to
+// the start of buf, and reads more after it.
+func (ur *runeUnitReader) fill() {
+	if ur.buf == nil {
+		ur.buf = make([]byte, utf8.UTFMax+4096)
+	}
+	ur.raw = ur.buf[:copy(ur.buf, ur.raw)]
+	n, err := ur.r.Read(ur.buf[len(ur.raw):])
+	ur.raw = ur.buf[:len(ur.raw)+n]
+	ur.err = err
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (ur *runeUnitReader) Close() error {
+	closer, isCloser := ur.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// unitBoundary turns the runeUnits returned by a boundary search back
+// into bytes. Each Read returns bytes from only one Read of the units, so
+// a match that was returned whole stays whole.
+type unitBoundary struct {
+	units   advstreamtools.GeneralReadCloser[runeUnit]
+	unitBuf []runeUnit
+	// pending is the bytes of the last Read of the units that have
+	// not been returned yet. It is always a window into pendingBuf.
+	pending    []byte
+	pendingBuf []byte
+}
+
+// Read implements io.Reader.
+func (ub *unitBoundary) Read(buf []byte) (int, error) {
+	if len(ub.pending) == 0 {
+		if cap(ub.unitBuf) < len(buf) {
+			ub.unitBuf = make([]runeUnit, len(buf))
+		}
+		n, err := ub.units.Read(ub.unitBuf[:len(buf)])
+		ub.pendingBuf = ub.pendingBuf[:0]
+		for _, u := range ub.unitBuf[:n] {
+			ub.pendingBuf = append(ub.pendingBuf, u.bytes[:max(u.size, 1)]...)
+		}
+		ub.pending = ub.pendingBuf
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(buf, ub.pending)
+	ub.pending = ub.pending[n:]
+	return n, nil
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (ub *unitBoundary) Close() error {
+	return ub.units.Close()
+}
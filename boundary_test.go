@@ -0,0 +1,123 @@
+package streamtools
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+func readChunks(t *testing.T, r io.Reader, size int) []string {
+	t.Helper()
+	chunks := []string{}
+	for {
+		buf := make([]byte, size)
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunks = append(chunks, string(buf[:n]))
+		}
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestBoundaryStringFolded(t *testing.T) {
+	for idx, test := range []struct {
+		search  string
+		folding CaseFolding
+		in      []string
+		out     []string
+	}{
+		{
+			"password", CaseSensitive,
+			[]string{"Password=a&password=b"},
+			[]string{"Password=a&", "password", "=b"},
+		},
+		{
+			"password", FoldASCII,
+			[]string{"PassWORD=a&pass", "word=b&PASSWORT"},
+			[]string{"PassWORD", "=a&", "password", "=b&PASSWORT"},
+		},
+		{
+			// ASCII folding leaves other bytes alone.
+			"straße", FoldASCII,
+			[]string{"STRAßE STRASSE"},
+			[]string{"STRAßE", " STRASSE"},
+		},
+		{
+			// The Kelvin sign folds to k, and ſ to s, in more
+			// bytes than the search string, split across reads.
+			"kiss", FoldUnicode,
+			[]string{"a\xe2\x84", "\xaaIs\xc5", "\xbf!KISS"},
+			[]string{"a", "KIsſ", "!", "KISS"},
+		},
+		{
+			"Ωmega", FoldUnicode,
+			[]string{"ωMEGA \xff", "ΩMEG\xce", "\xa9"},
+			[]string{"ωMEGA", " \xffΩMEGΩ"},
+		},
+		{
+			// Invalid UTF-8 never matches, even U+FFFD.
+			"a�", FoldUnicode,
+			[]string{"A\xff a�"},
+			[]string{"A\xff ", "a�"},
+		},
+	} {
+		src := streamtest.NewChunkReader(test.in...)
+		out := readChunks(t, NewBoundaryStringFolded(src, test.search, test.folding), 32)
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("case %d: got %q, want %q", idx, out, test.out)
+		}
+	}
+}
+
+func TestBoundaryStringFoldedAllocs(t *testing.T) {
+	// Reading more of the stream allocates nothing more.
+	buf := make([]byte, 512)
+	allocs := func(size int) float64 {
+		text := strings.Repeat("Straße, STRASSE; ", size/17)
+		src := strings.NewReader(text)
+		return testing.AllocsPerRun(10, func() {
+			src.Reset(text)
+			r := NewBoundaryStringFolded(src, "strasse", FoldUnicode)
+			for {
+				if _, err := r.Read(buf); err != nil {
+					break
+				}
+			}
+		})
+	}
+	if short, long := allocs(1<<12), allocs(1<<18); long != short {
+		t.Fatalf("%v allocations for a short stream, %v for a long one", short, long)
+	}
+}
+
+func TestBoundaryStringPool(t *testing.T) {
+	pool := NewBoundaryStringPool("password")
+	for _, body := range []string{
+		"username=moo&password=mumble",
+		"password=x",
+		"nothing here",
+	} {
+		r := pool.Get(streamtest.NewChunkReader(body))
+		chunks := readChunks(t, r, 32)
+		pool.Put(r)
+
+		want := []string{body}
+		if before, after, found := strings.Cut(body, "password"); found {
+			want = []string{before, "password", after}
+			if before == "" {
+				want = want[1:]
+			}
+		}
+		if !reflect.DeepEqual(chunks, want) {
+			t.Fatalf("%q: read %q, want %q", body, chunks, want)
+		}
+	}
+}
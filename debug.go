@@ -9,6 +9,23 @@ const (
 	// ErrBufferTooSmall indicates that a Read call was made with a
 	// buffer too small for some guarantee to be held.
 	ErrBufferTooSmall = ErrorType(iota + 1)
+
+	// ErrDigestMismatch indicates that a stream's digest did not match
+	// the digest it was expected to have.
+	ErrDigestMismatch
+
+	// ErrInvalidUTF8 indicates that a stream required to be UTF-8 was
+	// not.
+	ErrInvalidUTF8
+
+	// ErrInvalidUnread indicates that an attempt was made to unread
+	// something that can not be unread.
+	ErrInvalidUnread
+
+	// ErrLimitExceeded indicates that an operation on a stream went
+	// over a limit placed on the work it may do or the data it may
+	// hold.
+	ErrLimitExceeded
 )
 
 // ErrorType is a constant that indicates the type of error that has
@@ -56,3 +73,10 @@ func errorf(ty ErrorType, format string, args ...any) StreamError {
 		st,
 	}
 }
+
+// NewLimitError returns a StreamError of type ErrLimitExceeded, with the
+// message formatted as fmt.Errorf would format it, for the packages that
+// build on this one to report going over a limit.
+func NewLimitError(format string, args ...any) StreamError {
+	return errorf(ErrLimitExceeded, format, args...)
+}
@@ -0,0 +1,120 @@
+package streamtools
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"sort"
+)
+
+// A DigestTag carries the digests computed by a DigestReader over the
+// entire stream, keyed by the names the hashes were given.
+type DigestTag struct {
+	Digests map[string][]byte
+}
+
+// Unwrap implements the Tag interface. DigestTags wrap nothing.
+func (dt DigestTag) Unwrap() []Tag {
+	return nil
+}
+
+// DigestReader passes an underlying stream through unchanged, while
+// running all of the bytes through a set of hash.Hash values.
+//
+// DigestReader is a TaggedReader. The Read call that returns io.EOF
+// will also return a DigestTag containing the final digests. Since
+// DigestReader does not read ahead, and many readers only report io.EOF
+// on a Read call after the last data, this Read call may return no
+// bytes.
+//
+// If expected digests have been registered with Expect, they are checked
+// when the end of the stream is reached, and if any of them do not match,
+// a StreamError with ErrDigestMismatch is returned instead of io.EOF. The
+// DigestTag is still returned with it.
+//
+// Errors other than io.EOF from the underlying reader are passed through,
+// and no digests are computed.
+type DigestReader struct {
+	r        io.Reader
+	hashes   map[string]hash.Hash
+	expected map[string][]byte
+	final    error
+	tag      DigestTag
+}
+
+// NewDigestReader returns a new DigestReader that runs the stream through
+// the given hashes. The hashes should be freshly created, or Reset, as the
+// DigestReader does not reset them itself.
+func NewDigestReader(src io.Reader, hashes map[string]hash.Hash) *DigestReader {
+	return &DigestReader{
+		r:        src,
+		hashes:   hashes,
+		expected: map[string][]byte{},
+	}
+}
+
+// Expect registers the expected digest for the hash with the given name.
+// This panics if there is no such hash.
+func (dr *DigestReader) Expect(name string, digest []byte) {
+	if _, have := dr.hashes[name]; !have {
+		panic("streamtools: no hash named " + name)
+	}
+	dr.expected[name] = digest
+}
+
+// Read implements the TaggedReader interface.
+func (dr *DigestReader) Read(buf []byte) (int, Tag, error) {
+	if dr.final != nil {
+		return 0, nil, dr.final
+	}
+
+	n, err := dr.r.Read(buf)
+	for _, h := range dr.hashes {
+		// hash.Hash's Write is documented to never return an error
+		_, _ = h.Write(buf[:n])
+	}
+	if err != io.EOF {
+		return n, nil, err
+	}
+
+	dr.tag = DigestTag{map[string][]byte{}}
+	for name, h := range dr.hashes {
+		dr.tag.Digests[name] = h.Sum(nil)
+	}
+	dr.final = dr.verify()
+	return n, dr.tag, dr.final
+}
+
+func (dr *DigestReader) verify() error {
+	names := make([]string, 0, len(dr.expected))
+	for name := range dr.expected {
+		names = append(names, name)
+	}
+	// so the error is deterministic if more than one mismatches
+	sort.Strings(names)
+
+	for _, name := range names {
+		expected := dr.expected[name]
+		if !bytes.Equal(dr.tag.Digests[name], expected) {
+			return errorf(ErrDigestMismatch,
+				"streamtools: %s digest mismatch: got %x, expected %x",
+				name, dr.tag.Digests[name], expected)
+		}
+	}
+	return io.EOF
+}
+
+// Sum returns the digest computed for the hash of the given name, or nil
+// if the end of the stream has not been reached yet.
+func (dr *DigestReader) Sum(name string) []byte {
+	return dr.tag.Digests[name]
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (dr *DigestReader) Close() error {
+	closer, isCloser := dr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
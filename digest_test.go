@@ -0,0 +1,83 @@
+package streamtools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+func TestDigestReader(t *testing.T) {
+	content := "hello, world"
+	sha := sha256.Sum256([]byte(content))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(content))
+	crcSum := crc.Sum(nil)
+
+	dr := NewDigestReader(
+		streamtest.NewChunkReader("hello", ", ", "world"),
+		map[string]hash.Hash{
+			"sha256": sha256.New(),
+			"crc32":  crc32.NewIEEE(),
+		},
+	)
+	dr.Expect("sha256", sha[:])
+
+	out := &bytes.Buffer{}
+	buf := make([]byte, 16)
+	var digestTag DigestTag
+	for {
+		n, tag, err := dr.Read(buf)
+		out.Write(buf[:n])
+		if tag != nil {
+			if err == nil {
+				t.Fatalf("digest tag returned before the end of the stream")
+			}
+			if !TagAs(tag, &digestTag) {
+				t.Fatalf("unexpected tag type: %T", tag)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if out.String() != content {
+		t.Fatalf("stream not passed through: %q", out.String())
+	}
+	if !bytes.Equal(digestTag.Digests["sha256"], sha[:]) {
+		t.Fatalf("wrong sha256 digest")
+	}
+	if !bytes.Equal(digestTag.Digests["crc32"], crcSum) ||
+		!bytes.Equal(dr.Sum("crc32"), crcSum) {
+		t.Fatalf("wrong crc32 digest")
+	}
+
+	// once done, stays done
+	n, tag, err := dr.Read(buf)
+	if n != 0 || tag != nil || err != io.EOF {
+		t.Fatalf("unexpected read after EOF: %d %v %v", n, tag, err)
+	}
+}
+
+func TestDigestReaderMismatch(t *testing.T) {
+	dr := NewDigestReader(
+		streamtest.NewChunkReader("hello"),
+		map[string]hash.Hash{"sha256": sha256.New()},
+	)
+	dr.Expect("sha256", []byte("not the digest"))
+
+	_, err := io.ReadAll(Untagged(dr))
+	var se StreamError
+	if !errors.As(err, &se) || se.ErrorType != ErrDigestMismatch {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/thejerf/streamtools
 
-go 1.20
+go 1.23
 
 require github.com/davecgh/go-spew v1.1.1
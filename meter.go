@@ -0,0 +1,131 @@
+package streamtools
+
+import (
+	"encoding/json"
+	"io"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// A MeterSnapshot is a point-in-time summary of the traffic that has
+// passed through a MeteredReader.
+type MeterSnapshot struct {
+	// Bytes is the total number of bytes returned by Read.
+	Bytes int64
+	// Reads is the total number of Read calls made.
+	Reads int64
+	// Elapsed is the time since the MeteredReader was created.
+	Elapsed time.Duration
+	// BytesPerSecond is Bytes divided by Elapsed.
+	BytesPerSecond float64
+	// ReadSizes is a histogram of the sizes returned by Read calls.
+	// ReadSizes[i] counts the Reads that returned n bytes where
+	// bits.Len(n) == i; that is, ReadSizes[0] counts Reads that
+	// returned nothing, ReadSizes[1] Reads of one byte, ReadSizes[2]
+	// Reads of two or three bytes, ReadSizes[3] Reads of four to seven
+	// bytes, and so on. It is trimmed after the last non-empty bucket.
+	ReadSizes []int64
+}
+
+// MeteredReader passes through the bytes of an underlying reader
+// unchanged, while recording how much data has passed through it and how
+// it was chunked.
+//
+// The snapshot may be obtained at any time from any goroutine, so it is
+// safe to hand a MeteredReader to expvar.Publish; its String method
+// returns the JSON encoding of the current snapshot.
+type MeteredReader struct {
+	r      io.Reader
+	report func(MeterSnapshot)
+	now    func() time.Time
+
+	m         sync.Mutex
+	start     time.Time
+	bytes     int64
+	reads     int64
+	readSizes [bits.UintSize + 1]int64
+}
+
+// NewMeteredReader returns a MeteredReader wrapping the given reader. If
+// report is not nil, it is called with a fresh snapshot after every Read
+// call, on the goroutine making the Read call.
+//
+// The MeteredReader can always be closed; Close will close the underlying
+// reader if it is an io.Closer.
+func NewMeteredReader(src io.Reader, report func(MeterSnapshot)) *MeteredReader {
+	return &MeteredReader{
+		r:      src,
+		report: report,
+		now:    time.Now,
+		start:  time.Now(),
+	}
+}
+
+// Read implements io.Reader.
+func (mr *MeteredReader) Read(buf []byte) (int, error) {
+	n, err := mr.r.Read(buf)
+
+	mr.m.Lock()
+	mr.bytes += int64(n)
+	mr.reads++
+	mr.readSizes[bits.Len(uint(n))]++
+	var snapshot MeterSnapshot
+	if mr.report != nil {
+		snapshot = mr.snapshot()
+	}
+	mr.m.Unlock()
+
+	if mr.report != nil {
+		mr.report(snapshot)
+	}
+	return n, err
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (mr *MeteredReader) Close() error {
+	closer, isCloser := mr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Snapshot returns the current state of the meter.
+func (mr *MeteredReader) Snapshot() MeterSnapshot {
+	mr.m.Lock()
+	defer mr.m.Unlock()
+	return mr.snapshot()
+}
+
+// snapshot must be called with the lock held.
+func (mr *MeteredReader) snapshot() MeterSnapshot {
+	elapsed := mr.now().Sub(mr.start)
+	var bps float64
+	if elapsed > 0 {
+		bps = float64(mr.bytes) / elapsed.Seconds()
+	}
+
+	last := len(mr.readSizes)
+	for last > 0 && mr.readSizes[last-1] == 0 {
+		last--
+	}
+	return MeterSnapshot{
+		Bytes:          mr.bytes,
+		Reads:          mr.reads,
+		Elapsed:        elapsed,
+		BytesPerSecond: bps,
+		ReadSizes:      append([]int64(nil), mr.readSizes[:last]...),
+	}
+}
+
+// String returns the current snapshot encoded as JSON, which makes the
+// MeteredReader an expvar.Var.
+func (mr *MeteredReader) String() string {
+	b, err := json.Marshal(mr.Snapshot())
+	if err != nil {
+		// can't happen; the snapshot is all numbers.
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,61 @@
+package streamtools
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+// A compile-time check that the meter can be published.
+var _ expvar.Var = (*MeteredReader)(nil)
+
+func TestMeteredReader(t *testing.T) {
+	src := streamtest.NewChunkReader("a", "bc", "defg", "", "hijklmnop")
+	start := time.Unix(1000000, 0)
+	now := start
+
+	reports := 0
+	mr := NewMeteredReader(src, func(MeterSnapshot) { reports++ })
+	mr.start = start
+	mr.now = func() time.Time { return now }
+
+	buf := make([]byte, 32)
+	for {
+		now = now.Add(time.Second)
+		_, err := mr.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	snapshot := mr.Snapshot()
+	expected := MeterSnapshot{
+		Bytes:          16,
+		Reads:          6,
+		Elapsed:        6 * time.Second,
+		BytesPerSecond: 16.0 / 6,
+		ReadSizes:      []int64{2, 1, 1, 1, 1},
+	}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Fatalf("unexpected snapshot: %#v", snapshot)
+	}
+	if reports != 6 {
+		t.Fatalf("unexpected number of reports: %d", reports)
+	}
+
+	var decoded MeterSnapshot
+	if err := json.Unmarshal([]byte(mr.String()), &decoded); err != nil {
+		t.Fatalf("can't decode String output: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("String did not round trip: %#v", decoded)
+	}
+}
@@ -0,0 +1,21 @@
+package streamtools
+
+import (
+	"io"
+
+	"github.com/thejerf/streamtools/advstreamtools"
+)
+
+// PeekReader is an io.Reader that can be peeked into, and given back
+// data that has been read from it, as much as needed. ReadUntil,
+// ReadUntilAny and ReadUntilSequence read a PeekReader in bulk, giving
+// back whatever they read past the end of what they were looking for,
+// rather than reading it a byte at a time.
+//
+// See advstreamtools.PeekReader.
+type PeekReader = advstreamtools.PeekReader[byte]
+
+// NewPeekReader returns a new PeekReader reading from src.
+func NewPeekReader(src io.Reader) *PeekReader {
+	return advstreamtools.NewPeekReader[byte](src)
+}
@@ -0,0 +1,172 @@
+package streamtools
+
+import (
+	"io"
+	"time"
+)
+
+// tokenBucket is a classic token bucket, with one token per byte. It is
+// not thread-safe; each rate-limited stream carries its own.
+type tokenBucket struct {
+	rate   float64 // tokens added per second
+	burst  float64 // maximum number of tokens the bucket can hold
+	tokens float64
+	last   time.Time
+
+	// these are swapped out by the test code.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newTokenBucket(bytesPerSecond float64, burst int) *tokenBucket {
+	if bytesPerSecond <= 0 {
+		panic("streamtools: rate must be positive")
+	}
+	if burst < 1 {
+		panic("streamtools: burst must be at least 1")
+	}
+	return &tokenBucket{
+		rate:   bytesPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+func (tb *tokenBucket) refill() {
+	now := tb.now()
+	elapsed := now.Sub(tb.last)
+	tb.last = now
+	if elapsed <= 0 {
+		return
+	}
+	tb.tokens += elapsed.Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// take blocks until at least one token is available, then takes as many
+// as it can up to n, returning the number taken.
+func (tb *tokenBucket) take(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	tb.refill()
+	for tb.tokens < 1 {
+		wait := (1 - tb.tokens) / tb.rate
+		tb.sleep(time.Duration(wait * float64(time.Second)))
+		tb.refill()
+	}
+	if avail := int(tb.tokens); n > avail {
+		n = avail
+	}
+	tb.tokens -= float64(n)
+	return n
+}
+
+// giveBack returns tokens that were taken but not used, such as when a
+// Read returns fewer bytes than were asked for.
+func (tb *tokenBucket) giveBack(n int) {
+	tb.tokens += float64(n)
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// NewRateLimitedReader returns a reader that will yield no more than
+// bytesPerSecond bytes per second from the source on average, using a token
+// bucket that starts full and holds up to burst bytes.
+//
+// A single Read call will never return more than burst bytes, so burst
+// should generally be at least as large as the buffers you expect to be
+// reading with. Read blocks until at least one byte may be read.
+//
+// This panics if bytesPerSecond is not positive or burst is less than 1.
+func NewRateLimitedReader(src io.Reader, bytesPerSecond float64, burst int) io.Reader {
+	return &rateLimitedReader{src, newTokenBucket(bytesPerSecond, burst)}
+}
+
+// NewRateLimitedReadCloser is the same as NewRateLimitedReader, except it
+// returns something that can also be closed.
+func NewRateLimitedReadCloser(src io.ReadCloser, bytesPerSecond float64, burst int) io.ReadCloser {
+	return &rateLimitedReader{src, newTokenBucket(bytesPerSecond, burst)}
+}
+
+type rateLimitedReader struct {
+	r  io.Reader
+	tb *tokenBucket
+}
+
+// Read implements io.Reader.
+func (rlr *rateLimitedReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return rlr.r.Read(buf)
+	}
+	allowed := rlr.tb.take(len(buf))
+	n, err := rlr.r.Read(buf[:allowed])
+	rlr.tb.giveBack(allowed - n)
+	return n, err
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (rlr *rateLimitedReader) Close() error {
+	closer, isCloser := rlr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// NewRateLimitedWriter returns a writer that will pass no more than
+// bytesPerSecond bytes per second on to the destination on average, using
+// a token bucket that starts full and holds up to burst bytes.
+//
+// Writes larger than the burst are broken up into multiple writes to the
+// destination. Write blocks until all of its bytes have been written, or
+// the destination returns an error.
+//
+// This panics if bytesPerSecond is not positive or burst is less than 1.
+func NewRateLimitedWriter(dst io.Writer, bytesPerSecond float64, burst int) io.Writer {
+	return &rateLimitedWriter{dst, newTokenBucket(bytesPerSecond, burst)}
+}
+
+// NewRateLimitedWriteCloser is the same as NewRateLimitedWriter, except
+// it returns something that can also be closed.
+func NewRateLimitedWriteCloser(dst io.WriteCloser, bytesPerSecond float64, burst int) io.WriteCloser {
+	return &rateLimitedWriter{dst, newTokenBucket(bytesPerSecond, burst)}
+}
+
+type rateLimitedWriter struct {
+	w  io.Writer
+	tb *tokenBucket
+}
+
+// Write implements io.Writer.
+func (rlw *rateLimitedWriter) Write(buf []byte) (int, error) {
+	written := 0
+	for written < len(buf) {
+		allowed := rlw.tb.take(len(buf) - written)
+		n, err := rlw.w.Write(buf[written : written+allowed])
+		written += n
+		rlw.tb.giveBack(allowed - n)
+		if err != nil {
+			return written, err
+		}
+		if n < allowed {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Close will close the underlying writer if it is an io.Closer.
+func (rlw *rateLimitedWriter) Close() error {
+	closer, isCloser := rlw.w.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package streamtools
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) Sleep(d time.Duration) {
+	fc.slept += d
+	fc.now = fc.now.Add(d)
+}
+
+func (fc *fakeClock) install(tb *tokenBucket) {
+	tb.now = fc.Now
+	tb.sleep = fc.Sleep
+	tb.last = fc.now
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000000, 0)}
+	src := strings.NewReader(strings.Repeat("a", 1000))
+	r := NewRateLimitedReader(src, 100, 10)
+	fc.install(r.(*rateLimitedReader).tb)
+
+	buf := make([]byte, 64)
+	total := 0
+	for {
+		n, err := r.Read(buf)
+		if n > 10 {
+			t.Fatalf("read %d bytes, more than the burst", n)
+		}
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if total != 1000 {
+		t.Fatalf("read %d bytes, expected 1000", total)
+	}
+
+	// The first 10 bytes are free because the bucket starts full, the
+	// other 990 come in at 100 per second.
+	if fc.slept < 9800*time.Millisecond || fc.slept > 10*time.Second {
+		t.Fatalf("unexpected time spent sleeping: %v", fc.slept)
+	}
+}
+
+func TestRateLimitedReaderRefund(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000000, 0)}
+	r := NewRateLimitedReader(strings.NewReader("abc"), 1, 10)
+	rlr := r.(*rateLimitedReader)
+	fc.install(rlr.tb)
+
+	buf := make([]byte, 10)
+	n, _ := r.Read(buf)
+	if n != 3 {
+		t.Fatalf("unexpected read size %d", n)
+	}
+	if rlr.tb.tokens != 7 {
+		t.Fatalf("unused tokens were not given back: %v", rlr.tb.tokens)
+	}
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestRateLimitedWriter(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000000, 0)}
+	dst := &closeRecorder{}
+	w := NewRateLimitedWriteCloser(dst, 50, 5)
+	fc.install(w.(*rateLimitedWriter).tb)
+
+	n, err := w.Write([]byte(strings.Repeat("b", 105)))
+	if n != 105 || err != nil {
+		t.Fatalf("unexpected write result: %d %v", n, err)
+	}
+	if dst.String() != strings.Repeat("b", 105) {
+		t.Fatalf("wrong bytes written")
+	}
+	if fc.slept < 1900*time.Millisecond || fc.slept > 2*time.Second {
+		t.Fatalf("unexpected time spent sleeping: %v", fc.slept)
+	}
+
+	_ = w.Close()
+	if !dst.closed {
+		t.Fatalf("close was not passed through")
+	}
+}
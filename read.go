@@ -1,6 +1,12 @@
 package streamtools
 
-import "io"
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/thejerf/streamtools/advstreamtools"
+)
 
 // This contains reader-centric operations.
 
@@ -10,25 +16,14 @@ import "io"
 // read completed, and false if there is more to read yet. error will be
 // returned from the underlying reader, if any.
 //
-// The checked byte will be consumed, but nothing else will be.
+// The checked byte will be consumed, but nothing else will be. To manage
+// that efficiently, a PeekReader or a *bufio.Reader is scanned in bulk,
+// and an io.ByteReader is read with ReadByte. Anything else is read with
+// one-byte Read calls.
 func ReadUntil(r io.Reader, b byte, buf []byte) (int, bool, error) {
-	mybuf := make([]byte, 1)
-	var n int
-	var err error
-	for idx := range buf {
-		n, err = r.Read(mybuf)
-		if n == 1 {
-			if mybuf[0] == b {
-				return idx, true, nil
-			}
-			buf[idx] = mybuf[0]
-		}
-		if err != nil {
-			return idx, true, err
-		}
-	}
-
-	return len(buf), false, nil
+	return readUntil(r, func(data []byte) int {
+		return bytes.IndexByte(data, b)
+	}, buf)
 }
 
 // ReadUntilAny will read the given reader until one of the given bytes is
@@ -37,7 +32,8 @@ func ReadUntil(r io.Reader, b byte, buf []byte) (int, bool, error) {
 // read completed, and false if there is more to read yet. error will be
 // returned from the underlying reader, if any.
 //
-// The checked byte will be consumed, but nothing else will be.
+// The checked byte will be consumed, but nothing else will be. Readers
+// are read as they are by ReadUntil.
 //
 // If no ends bytes are passed, this degenerates into a call to io.ReadFull,
 // except ErrUnexpectedEOF will be ignored because the caller is not trying
@@ -54,26 +50,202 @@ func ReadUntilAny(r io.Reader, ends []byte, buf []byte) (int, bool, error) {
 		return n, false, nil
 	}
 
+	var isEnd [256]bool
+	for _, b := range ends {
+		isEnd[b] = true
+	}
+	return readUntil(r, func(data []byte) int {
+		for idx, c := range data {
+			if isEnd[c] {
+				return idx
+			}
+		}
+		return -1
+	}, buf)
+}
+
+// readUntil implements ReadUntil and ReadUntilAny. index returns the
+// index of the first end byte in data, or -1 if there is none.
+func readUntil(r io.Reader, index func([]byte) int, buf []byte) (int, bool, error) {
+	switch src := r.(type) {
+	case advstreamtools.Unreader[byte]:
+		return readUntilUnread(src, index, buf)
+
+	case *bufio.Reader:
+		return readUntilBuffered(src, index, buf)
+
+	case io.ByteReader:
+		for idx := range buf {
+			c, err := src.ReadByte()
+			if err != nil {
+				return idx, true, err
+			}
+			buf[idx] = c
+			if index(buf[idx:idx+1]) == 0 {
+				return idx, true, nil
+			}
+		}
+		return len(buf), false, nil
+	}
+
 	mybuf := make([]byte, 1)
 	var n int
 	var err error
 	for idx := range buf {
 		n, err = r.Read(mybuf)
 		if n == 1 {
-			// generally a for loop is faster than a map lookup
-			// belowe about 10 elements, which is probably the
-			// common case.
-			for _, b := range ends {
-				if mybuf[0] == b {
-					return idx, true, nil
-				}
-			}
 			buf[idx] = mybuf[0]
+			if index(buf[idx:idx+1]) == 0 {
+				return idx, true, nil
+			}
+		}
+		if err != nil {
+			return idx, true, err
+		}
+	}
+
+	return len(buf), false, nil
+}
+
+// readUntilUnread is readUntil for a reader that can be given back what
+// was read past the end byte, so it can be read in bulk.
+func readUntilUnread(u advstreamtools.Unreader[byte], index func([]byte) int, buf []byte) (int, bool, error) {
+	idx := 0
+	for idx < len(buf) {
+		n, err := u.Read(buf[idx:])
+		if end := index(buf[idx : idx+n]); end >= 0 {
+			u.Unread(buf[idx+end+1 : idx+n])
+			return idx + end, true, nil
 		}
+		idx += n
 		if err != nil {
 			return idx, true, err
 		}
 	}
+	return len(buf), false, nil
+}
+
+// readUntilBuffered is readUntil for a bufio.Reader, scanning what it
+// has buffered and only consuming what is used.
+func readUntilBuffered(br *bufio.Reader, index func([]byte) int, buf []byte) (int, bool, error) {
+	idx := 0
+	for idx < len(buf) {
+		data, err := br.Peek(max(br.Buffered(), 1))
+		data = data[:min(len(data), len(buf)-idx)]
+		if end := index(data); end >= 0 {
+			copy(buf[idx:], data[:end])
+			_, _ = br.Discard(end + 1)
+			return idx + end, true, nil
+		}
+		n := copy(buf[idx:], data)
+		_, _ = br.Discard(n)
+		idx += n
+		if err != nil {
+			return idx, true, err
+		}
+	}
+	return len(buf), false, nil
+}
+
+// ReadUntilSequence will read the given reader until the given sequence
+// of bytes is encountered, such as the "\r\n\r\n" at the end of HTTP
+// headers, and put the values before it into the buffer. The return
+// values are as for ReadUntil.
+//
+// The delimiter will be consumed, but nothing after it will be. A
+// PeekReader or a *bufio.Reader is scanned in bulk. Otherwise, an
+// io.ByteReader is read with ReadByte, and anything else with one-byte
+// Read calls.
+//
+// If the buffer fills up part way through what may turn out to be the
+// delimiter, a PeekReader or *bufio.Reader keeps those bytes, so that
+// the next call will find the delimiter. Other readers cannot keep them,
+// so advstreamtools.ErrSequenceSplit is returned instead; to read such a
+// reader a delimited section at a time, use a SequenceReader. See
+// advstreamtools.ReadUntilSequence.
+func ReadUntilSequence(r io.Reader, delim []byte, buf []byte) (int, bool, error) {
+	switch src := r.(type) {
+	case advstreamtools.Unreader[byte]:
+		return advstreamtools.ReadUntilSequence(src, delim, buf)
+	case *bufio.Reader:
+		if len(delim) > 0 {
+			return readUntilSequenceBuffered(src, delim, buf)
+		}
+	case io.ByteReader:
+		return advstreamtools.ReadUntilSequence(byteReaderAdapter{src}, delim, buf)
+	}
+	return advstreamtools.ReadUntilSequence(r, delim, buf)
+}
+
+// readUntilSequenceBuffered is ReadUntilSequence for a bufio.Reader. It
+// leaves anything that may be the start of the delimiter in the reader's
+// buffer until it knows whether it is.
+func readUntilSequenceBuffered(br *bufio.Reader, delim []byte, buf []byte) (int, bool, error) {
+	idx := 0
+	want := 1
+	for idx < len(buf) {
+		room := len(buf) - idx
+		data, err := br.Peek(max(want, br.Buffered()))
+		data = data[:min(len(data), room+len(delim))]
+		if end := bytes.Index(data, delim); end >= 0 && end <= room {
+			copy(buf[idx:], data[:end])
+			_, _ = br.Discard(end + len(delim))
+			return idx + end, true, nil
+		}
+
+		// Bytes that the delimiter could start in, were more read,
+		// are left in the reader, unless the stream has ended.
+		safe := len(data) - (len(delim) - 1)
+		switch {
+		case err == bufio.ErrBufferFull:
+			// The delimiter is longer than the reader's buffer;
+			// the first byte is not the start of it.
+			safe = max(safe, 1)
+		case err != nil:
+			safe = len(data)
+		}
+		safe = max(min(safe, room), 0)
+		copy(buf[idx:], data[:safe])
+		_, _ = br.Discard(safe)
+		idx += safe
 
+		if err != nil && err != bufio.ErrBufferFull && safe == len(data) {
+			return idx, true, err
+		}
+		want = 1
+		if safe == 0 {
+			want = len(data) + 1
+		}
+	}
 	return len(buf), false, nil
 }
+
+// SequenceReader reads a stream a delimited section at a time, such as
+// the headers of a series of HTTP-style messages read from a socket,
+// finding each delimiter however the stream is split up.
+//
+// See advstreamtools.SequenceReader.
+type SequenceReader = advstreamtools.SequenceReader[byte]
+
+// NewSequenceReader returns a SequenceReader reading sections of src
+// delimited by delim.
+func NewSequenceReader(src io.Reader, delim []byte) *SequenceReader {
+	return advstreamtools.NewSequenceReader[byte](src, delim)
+}
+
+// byteReaderAdapter reads an io.ByteReader a byte at a time.
+type byteReaderAdapter struct {
+	br io.ByteReader
+}
+
+func (bra byteReaderAdapter) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	b, err := bra.br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	buf[0] = b
+	return 1, nil
+}
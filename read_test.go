@@ -1,8 +1,15 @@
 package streamtools
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/rand"
 	"strings"
 	"testing"
+	"testing/iotest"
+
+	"github.com/thejerf/streamtools/advstreamtools"
 )
 
 type readUntilTest struct {
@@ -134,3 +141,246 @@ func TestReadUntilAny(t *testing.T) {
 		}
 	}
 }
+
+type readUntilSequenceTest struct {
+	Input     string
+	Delim     string
+	Output    string
+	Completed bool
+	// Split is set if a reader that cannot be given values back fails
+	// with ErrSequenceSplit.
+	Split bool
+	// Rest is what is left once the delimiter has been found.
+	Rest string
+}
+
+func TestReadUntilSequence(t *testing.T) {
+	for idx, test := range []readUntilSequenceTest{
+		{
+			"Host: x\r\n\r\nbody",
+			"\r\n\r\n",
+			"Host: x",
+			true,
+			false,
+			"body",
+		},
+		{
+			// partial delimiters that turn out not to be
+			"a\r\nb\r\r\n\r\nc",
+			"\r\n\r\n",
+			"a\r\nb\r",
+			true,
+			false,
+			"c",
+		},
+		{
+			"aabaabaaab!",
+			"aaab",
+			"aabaab",
+			true,
+			false,
+			"!",
+		},
+		{
+			"0123456789abcd\r\n\r\n",
+			"\r\n\r\n",
+			"0123456789abcd",
+			false,
+			false,
+			"",
+		},
+		{
+			// The delimiter straddles the end of the buffer, and
+			// is found by the next call.
+			"0123456789ab\r\n\r\nbody",
+			"\r\n\r\n",
+			"0123456789ab",
+			false,
+			true,
+			"body",
+		},
+		{
+			"012345678",
+			"",
+			"012345678",
+			true,
+			false,
+			"",
+		},
+	} {
+		for _, byteReader := range []bool{false, true} {
+			var r io.Reader = iotest.OneByteReader(strings.NewReader(test.Input))
+			if byteReader {
+				r = strings.NewReader(test.Input)
+			}
+			sr := NewSequenceReader(r, []byte(test.Delim))
+			buf := make([]byte, 14)
+			n, done, err := sr.ReadUntil(buf)
+			if err != nil && err != io.EOF {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if done != test.Completed {
+				t.Fatalf("in test %d, expected done to be %v, but it was %v",
+					idx, test.Completed, done)
+			}
+			if string(buf[:n]) != test.Output {
+				t.Fatalf("in test %d, got %q but expected %q",
+					idx, string(buf[:n]), test.Output)
+			}
+			if !done {
+				n, done, err = sr.ReadUntil(buf)
+				if n != 0 || !done || err != nil {
+					t.Fatalf("in test %d, next call got %q, %v, %v",
+						idx, buf[:n], done, err)
+				}
+			}
+			rest, _ := io.ReadAll(sr)
+			if string(rest) != test.Rest {
+				t.Fatalf("in test %d, left %q but expected %q",
+					idx, rest, test.Rest)
+			}
+
+			// Read without a SequenceReader, a delimiter split by
+			// the end of the buffer is an error.
+			r = iotest.OneByteReader(strings.NewReader(test.Input))
+			if byteReader {
+				r = strings.NewReader(test.Input)
+			}
+			n, done, err = ReadUntilSequence(r, []byte(test.Delim), buf)
+			if string(buf[:n]) != test.Output || done != test.Completed ||
+				(err == advstreamtools.ErrSequenceSplit) != test.Split {
+				t.Fatalf("in test %d, ReadUntilSequence got %q, %v, %v",
+					idx, buf[:n], done, err)
+			}
+		}
+	}
+}
+
+func TestReadUntilBulk(t *testing.T) {
+	// Lines read from a PeekReader or a bufio.Reader in bulk lose
+	// nothing after them.
+	input := "first\nsecond;third\r\n\r\nrest"
+	for _, r := range []io.Reader{
+		NewPeekReader(strings.NewReader(input)),
+		bufio.NewReader(iotest.HalfReader(strings.NewReader(input))),
+	} {
+		buf := make([]byte, 16)
+		n, done, err := ReadUntil(r, '\n', buf)
+		if string(buf[:n]) != "first" || !done || err != nil {
+			t.Fatalf("ReadUntil = %q, %v, %v", buf[:n], done, err)
+		}
+		n, done, err = ReadUntilAny(r, []byte{';', '\n'}, buf)
+		if string(buf[:n]) != "second" || !done || err != nil {
+			t.Fatalf("ReadUntilAny = %q, %v, %v", buf[:n], done, err)
+		}
+
+		// The delimiter does not fit in the buffer. A bufio.Reader
+		// can see that it follows, but otherwise it is found next
+		// time.
+		n, done, err = ReadUntilSequence(r, []byte("\r\n\r\n"), buf[:7])
+		if string(buf[:n]) != "third" || err != nil {
+			t.Fatalf("ReadUntilSequence = %q, %v, %v", buf[:n], done, err)
+		}
+		if !done {
+			n, done, err = ReadUntilSequence(r, []byte("\r\n\r\n"), buf)
+			if n != 0 || !done || err != nil {
+				t.Fatalf("ReadUntilSequence = %q, %v, %v", buf[:n], done, err)
+			}
+		}
+
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "rest" {
+			t.Fatalf("left %q", rest)
+		}
+
+		n, done, err = ReadUntil(r, '\n', buf)
+		if n != 0 || !done || err != io.EOF {
+			t.Fatalf("ReadUntil at the end = %q, %v, %v", buf[:n], done, err)
+		}
+	}
+}
+
+func BenchmarkReadUntil(b *testing.B) {
+	line := strings.Repeat("x", 1<<20) + "\n"
+	buf := make([]byte, len(line))
+	for _, bench := range []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"Reader", func(r io.Reader) io.Reader { return struct{ io.Reader }{r} }},
+		{"ByteReader", func(r io.Reader) io.Reader { return r }},
+		{"BufioReader", func(r io.Reader) io.Reader { return bufio.NewReader(r) }},
+		{"PeekReader", func(r io.Reader) io.Reader { return NewPeekReader(r) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(line)))
+			for i := 0; i < b.N; i++ {
+				r := bench.wrap(strings.NewReader(line))
+				if n, _, _ := ReadUntil(r, '\n', buf); n != len(line)-1 {
+					b.Fatalf("read %d bytes", n)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadUntilSequence(b *testing.B) {
+	headers := strings.Repeat("Header: value\r\n", 1<<16) + "\r\n"
+	buf := make([]byte, len(headers))
+	for _, bench := range []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"Reader", func(r io.Reader) io.Reader { return struct{ io.Reader }{r} }},
+		{"ByteReader", func(r io.Reader) io.Reader { return r }},
+		{"BufioReader", func(r io.Reader) io.Reader { return bufio.NewReader(r) }},
+		{"PeekReader", func(r io.Reader) io.Reader { return NewPeekReader(r) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(headers)))
+			for i := 0; i < b.N; i++ {
+				r := bench.wrap(strings.NewReader(headers))
+				if n, _, _ := ReadUntilSequence(r, []byte("\r\n\r\n"), buf); n != len(headers)-4 {
+					b.Fatalf("read %d bytes", n)
+				}
+			}
+		})
+	}
+}
+
+func TestReadUntilSequenceRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for range 500 {
+		input := make([]byte, rng.Intn(100))
+		for idx := range input {
+			input[idx] = "ab"[rng.Intn(2)]
+		}
+		delim := []string{"ab", "aab", "abab", "aaaaab"}[rng.Intn(4)]
+		want := strings.ReplaceAll(string(input), delim, "|")
+
+		for _, r := range []io.Reader{
+			bufio.NewReaderSize(iotest.HalfReader(bytes.NewReader(input)), 16),
+			NewPeekReader(iotest.HalfReader(bytes.NewReader(input))),
+		} {
+			buf := make([]byte, len(delim)+rng.Intn(10))
+			have := ""
+			for {
+				n, done, err := ReadUntilSequence(r, []byte(delim), buf)
+				have += string(buf[:n])
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if done {
+					have += "|"
+				}
+			}
+			if have != want {
+				t.Fatalf("%q split on %q with buffer %d: got %q, want %q",
+					input, delim, len(buf), have, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package streamtools
+
+import (
+	"cmp"
+	"io"
+	"slices"
+
+	"github.com/thejerf/streamtools/advstreamtools"
+)
+
+// NewReplacingReader returns a reader that passes src through with every
+// occurrence of each key of replacements replaced by its value, streaming
+// everything else as it comes. For instance, it can redact passwords
+// from a stream without the caller having to watch each Read for them.
+//
+// Where several keys match at the same place, the longest of them is
+// replaced. Replacements are never searched again, and empty keys are
+// ignored. A replacement longer than the buffer passed to Read is
+// returned across several Read calls.
+//
+// The returned reader will close src if it is an io.Closer. See
+// advstreamtools.NewReplacingReader for the details.
+func NewReplacingReader(src io.Reader, replacements map[string]string) io.ReadCloser {
+	searches := make([]string, 0, len(replacements))
+	for search := range replacements {
+		searches = append(searches, search)
+	}
+	slices.SortFunc(searches, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), cmp.Compare(a, b))
+	})
+
+	reps := make([]advstreamtools.Replacement[byte], len(searches))
+	for idx, search := range searches {
+		reps[idx] = advstreamtools.Replacement[byte]{
+			Search:  []byte(search),
+			Replace: []byte(replacements[search]),
+		}
+	}
+	return advstreamtools.NewReplacingReader[byte](src, reps)
+}
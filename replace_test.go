@@ -0,0 +1,25 @@
+package streamtools
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+func TestReplacingReader(t *testing.T) {
+	replacements := map[string]string{
+		"hunter2":   "*******",
+		"hunter":    "HUNTER",
+		"password":  "",
+		"secret":    "[a much longer redaction than the buffer]",
+		"":          "never used",
+		"unmatched": "x",
+	}
+	src := streamtest.NewChunkReader("pass", "word=hunt", "er2&user=", "hunter", "&secret", "s")
+	out := readChunks(t, NewReplacingReader(src, replacements), 8)
+	want := "=*******&user=HUNTER&[a much longer redaction than the buffer]s"
+	if strings.Join(out, "") != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,191 @@
+package streamtools
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// RuneReader adapts any io.Reader into an io.RuneScanner, using a buffer
+// of fixed size. This is what streamregexp's MatchReader,
+// FindReaderIndex, and FindReaderSubmatchIndex require.
+//
+// Unlike bufio.Reader, RuneReader keeps track of the byte position in the
+// stream, so after handing it to something that consumes runes it is
+// possible to tell exactly how much was consumed. Remainder then allows
+// the rest of the stream, including anything still sitting in the buffer,
+// to be passed on to further processing.
+//
+// Invalid UTF-8 is returned by ReadRune as utf8.RuneError with a size of
+// 1, per utf8.DecodeRune.
+type RuneReader struct {
+	r   io.Reader
+	buf []byte
+	// buf[start:end] is the buffered, unconsumed input.
+	start, end int
+	// pos is the position in the stream of buf[start].
+	pos int64
+	// lastSize is the size of the last rune read, or -1 if the last
+	// operation was not a ReadRune.
+	lastSize int
+	err      error
+}
+
+// NewRuneReader returns a new RuneReader reading from the given source
+// with a buffer of the given size. Sizes smaller than utf8.UTFMax are
+// increased to utf8.UTFMax.
+func NewRuneReader(src io.Reader, size int) *RuneReader {
+	if size < utf8.UTFMax {
+		size = utf8.UTFMax
+	}
+	return &RuneReader{
+		r:        src,
+		buf:      make([]byte, size),
+		lastSize: -1,
+	}
+}
+
+// fill reads more from the source into the buffer, keeping the bytes of
+// the last rune read so it can still be unread.
+func (rr *RuneReader) fill() {
+	keep := rr.start
+	if rr.lastSize > 0 {
+		keep -= rr.lastSize
+		if rr.end-keep == len(rr.buf) {
+			// no room; give up the ability to unread
+			keep = rr.start
+			rr.lastSize = -1
+		}
+	}
+	if keep > 0 {
+		copy(rr.buf, rr.buf[keep:rr.end])
+		rr.start -= keep
+		rr.end -= keep
+	}
+
+	n, err := rr.r.Read(rr.buf[rr.end:])
+	rr.end += n
+	rr.err = err
+}
+
+// ReadRune implements io.RuneReader. An error is returned only when no
+// more runes are available.
+func (rr *RuneReader) ReadRune() (rune, int, error) {
+	for !utf8.FullRune(rr.buf[rr.start:rr.end]) && rr.err == nil &&
+		rr.end-rr.start < utf8.UTFMax {
+		rr.fill()
+	}
+	if rr.start == rr.end {
+		rr.lastSize = -1
+		return 0, 0, rr.err
+	}
+
+	r, size := rune(rr.buf[rr.start]), 1
+	if r >= utf8.RuneSelf {
+		r, size = utf8.DecodeRune(rr.buf[rr.start:rr.end])
+	}
+	rr.start += size
+	rr.pos += int64(size)
+	rr.lastSize = size
+	return r, size, nil
+}
+
+// UnreadRune implements io.RuneScanner. As with bufio.Reader, only the
+// most recent rune read by ReadRune can be unread.
+func (rr *RuneReader) UnreadRune() error {
+	if rr.lastSize < 0 {
+		return errorf(ErrInvalidUnread,
+			"streamtools: UnreadRune called without a preceding ReadRune")
+	}
+	rr.start -= rr.lastSize
+	rr.pos -= int64(rr.lastSize)
+	rr.lastSize = -1
+	return nil
+}
+
+// Read implements io.Reader, so that the RuneReader can be used for
+// bytes as well.
+func (rr *RuneReader) Read(buf []byte) (int, error) {
+	rr.lastSize = -1
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	if rr.start == rr.end {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		// no sense copying a large read through the buffer
+		if len(buf) >= len(rr.buf) {
+			n, err := rr.r.Read(buf)
+			rr.pos += int64(n)
+			rr.err = err
+			if n == 0 {
+				return 0, err
+			}
+			return n, nil
+		}
+		rr.start, rr.end = 0, 0
+		rr.fill()
+		if rr.start == rr.end {
+			return 0, rr.err
+		}
+	}
+
+	n := copy(buf, rr.buf[rr.start:rr.end])
+	rr.start += n
+	rr.pos += int64(n)
+	return n, nil
+}
+
+// Pos returns the number of bytes that have been consumed from the
+// stream, through either ReadRune or Read.
+func (rr *RuneReader) Pos() int64 {
+	return rr.pos
+}
+
+// Buffered returns the number of bytes that have been read from the
+// source, but not yet consumed.
+func (rr *RuneReader) Buffered() int {
+	return rr.end - rr.start
+}
+
+// Remainder returns an io.Reader that yields the unconsumed bytes still in
+// the buffer, followed by the rest of the source. Once this is called, the
+// RuneReader should no longer be used.
+//
+// If the source has already returned an error, the returned reader will
+// return that error after the buffered bytes.
+func (rr *RuneReader) Remainder() io.Reader {
+	return &remainderReader{
+		buf: rr.buf[rr.start:rr.end],
+		r:   rr.r,
+		err: rr.err,
+	}
+}
+
+type remainderReader struct {
+	buf []byte
+	r   io.Reader
+	err error
+}
+
+func (rem *remainderReader) Read(buf []byte) (int, error) {
+	if len(rem.buf) > 0 {
+		n := copy(buf, rem.buf)
+		rem.buf = rem.buf[n:]
+		return n, nil
+	}
+	if rem.err != nil {
+		return 0, rem.err
+	}
+	return rem.r.Read(buf)
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (rr *RuneReader) Close() error {
+	closer, isCloser := rr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
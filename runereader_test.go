@@ -0,0 +1,141 @@
+package streamtools
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+func TestRuneReader(t *testing.T) {
+	// "日" is E6 97 A5, "€" is E2 82 AC
+	for _, size := range []int{1, 4, 5, 7, 64} {
+		var src io.Reader = streamtest.NewChunkReader("a\xe6", "\x97", "\xa5\xe2\x82", "\xac\xffb")
+		if size < 16 {
+			src = iotest.OneByteReader(strings.NewReader("a日€\xffb"))
+		}
+		rr := NewRuneReader(src, size)
+
+		runes := []rune{}
+		positions := []int64{}
+		for {
+			r, n, err := rr.ReadRune()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n != utf8.RuneLen(r) && r != utf8.RuneError {
+				t.Fatalf("wrong size %d for %q", n, r)
+			}
+			// every rune should be unreadable and rereadable
+			if err = rr.UnreadRune(); err != nil {
+				t.Fatalf("can't unread: %v", err)
+			}
+			if err = rr.UnreadRune(); err == nil {
+				t.Fatalf("unread twice")
+			}
+			r2, n2, _ := rr.ReadRune()
+			if r2 != r || n2 != n {
+				t.Fatalf("reread wrong rune")
+			}
+
+			runes = append(runes, r)
+			positions = append(positions, rr.Pos())
+		}
+
+		if !reflect.DeepEqual(runes, []rune{'a', '日', '€', utf8.RuneError, 'b'}) {
+			t.Fatalf("%d: unexpected runes: %q", size, runes)
+		}
+		if !reflect.DeepEqual(positions, []int64{1, 4, 7, 8, 9}) {
+			t.Fatalf("%d: unexpected positions: %v", size, positions)
+		}
+	}
+}
+
+func TestRuneReaderRemainder(t *testing.T) {
+	src := streamtest.NewChunkReader("abc日", "def", "ghi")
+	rr := NewRuneReader(src, 16)
+
+	for _, expected := range "abc" {
+		r, _, _ := rr.ReadRune()
+		if r != expected {
+			t.Fatalf("unexpected rune %q", r)
+		}
+	}
+	if rr.Pos() != 3 || rr.Buffered() != 3 {
+		t.Fatalf("unexpected position %d / buffered %d", rr.Pos(), rr.Buffered())
+	}
+
+	rest, err := io.ReadAll(rr.Remainder())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rest) != "日defghi" {
+		t.Fatalf("unexpected remainder %q", rest)
+	}
+}
+
+func TestRuneReaderRead(t *testing.T) {
+	src := streamtest.NewChunkReader("abcdef", "ghijklmnopqrstuvwxyz")
+	rr := NewRuneReader(src, 8)
+
+	r, _, _ := rr.ReadRune()
+	if r != 'a' {
+		t.Fatalf("unexpected rune %q", r)
+	}
+	buf := make([]byte, 3)
+	n, _ := rr.Read(buf)
+	if string(buf[:n]) != "bcd" || rr.Pos() != 4 {
+		t.Fatalf("unexpected read %q", buf[:n])
+	}
+	if rr.UnreadRune() == nil {
+		t.Fatalf("could unread after a Read")
+	}
+
+	rest, err := io.ReadAll(rr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rest) != "efghijklmnopqrstuvwxyz" || rr.Pos() != 26 {
+		t.Fatalf("unexpected rest %q", rest)
+	}
+}
+
+func TestRuneReaderEOF(t *testing.T) {
+	// An empty stream is at EOF straight away.
+	rr := NewRuneReader(strings.NewReader(""), 8)
+	if n, err := rr.Read(make([]byte, 3)); n != 0 || err != io.EOF {
+		t.Fatalf("Read of an empty stream = %d, %v", n, err)
+	}
+
+	// A stream that ends part way through a rune returns the bytes it
+	// has, then EOF.
+	rr = NewRuneReader(iotest.OneByteReader(strings.NewReader("a\xe2\x82")), 8)
+	if r, _, err := rr.ReadRune(); r != 'a' || err != nil {
+		t.Fatalf("ReadRune = %q, %v", r, err)
+	}
+	buf := make([]byte, 3)
+	got := []byte{}
+	for {
+		n, err := rr.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if n == 0 || err != nil {
+			t.Fatalf("Read = %d, %v", n, err)
+		}
+	}
+	if string(got) != "\xe2\x82" {
+		t.Fatalf("read %q", got)
+	}
+	if r, size, err := rr.ReadRune(); size != 0 || err != io.EOF {
+		t.Fatalf("ReadRune at EOF = %q, %d, %v", r, size, err)
+	}
+}
@@ -0,0 +1,150 @@
+package streamtools
+
+import (
+	"io"
+	"iter"
+)
+
+// SeparatorHandling determines whether SplitOnBoundary includes the
+// separators in the segments it returns.
+type SeparatorHandling int
+
+const (
+	// DropSeparator leaves the separators out of the segments.
+	DropSeparator = SeparatorHandling(iota)
+
+	// KeepSeparator ends each segment with the separator that ended
+	// it.
+	KeepSeparator
+)
+
+const splitBufSize = 4096
+
+// SplitOnBoundary returns an iterator over the segments of src separated
+// by occurrences of sep, found the same way NewBoundaryString finds them,
+// so that concatenated documents or the chunks of a protocol can be
+// processed one at a time.
+//
+// Each segment streams its data as it is read from src, so no segment is
+// ever held in memory in full. A segment is only valid until the next
+// iteration; anything not read from it by then is skipped, as is the
+// rest of a segment that is closed. Closing a segment does not close
+// src.
+//
+// A stream that ends with a separator does not have an empty segment
+// after it, so an empty stream has no segments at all. If sep is empty,
+// the whole stream is one segment.
+//
+// If reading src fails with an error other than io.EOF, the segment being
+// read at the time will return that error once it has returned what came
+// before it, and it will be the final segment.
+func SplitOnBoundary(src io.Reader, sep string, handling SeparatorHandling) iter.Seq[io.ReadCloser] {
+	return func(yield func(io.ReadCloser) bool) {
+		s := &boundarySplitter{
+			r:        src,
+			sep:      sep,
+			handling: handling,
+			buf:      make([]byte, max(splitBufSize, len(sep))),
+		}
+		if sep != "" {
+			s.r = NewBoundaryString(src, sep)
+		}
+
+		for {
+			// Only start a segment if there is something in it,
+			// even if it is just an error.
+			for len(s.chunk) == 0 && s.err == nil {
+				s.next()
+			}
+			if len(s.chunk) == 0 && s.err == io.EOF {
+				return
+			}
+
+			s.current = &boundarySegment{s: s}
+			if !yield(s.current) {
+				return
+			}
+			s.current.Close()
+			if len(s.chunk) == 0 && s.err != nil {
+				return
+			}
+		}
+	}
+}
+
+// boundarySplitter holds the state of a SplitOnBoundary. It reads the
+// stream a chunk at a time from the boundary search, which returns each
+// separator as a chunk of its own.
+type boundarySplitter struct {
+	r        io.Reader
+	sep      string
+	handling SeparatorHandling
+
+	buf []byte
+	// chunk is what is left of the last chunk read into buf.
+	chunk []byte
+	// isSep is set if chunk is (what is left of) a separator.
+	isSep bool
+	err   error
+
+	current *boundarySegment
+}
+
+// next reads the next chunk.
+func (s *boundarySplitter) next() {
+	n, err := s.r.Read(s.buf)
+	s.chunk = s.buf[:n]
+	s.isSep = s.sep != "" && string(s.chunk) == s.sep
+	s.err = err
+}
+
+// boundarySegment is a segment yielded by SplitOnBoundary.
+type boundarySegment struct {
+	s    *boundarySplitter
+	done bool
+}
+
+// Read implements io.Reader.
+func (seg *boundarySegment) Read(buf []byte) (int, error) {
+	s := seg.s
+	if seg.done || s.current != seg {
+		return 0, io.EOF
+	}
+	for len(s.chunk) == 0 {
+		if s.err != nil {
+			seg.done = true
+			return 0, s.err
+		}
+		s.next()
+	}
+
+	if s.isSep && s.handling != KeepSeparator {
+		s.chunk = nil
+		seg.done = true
+		return 0, io.EOF
+	}
+	n := copy(buf, s.chunk)
+	s.chunk = s.chunk[n:]
+	if s.isSep && len(s.chunk) == 0 {
+		seg.done = true
+	}
+	return n, nil
+}
+
+// Close skips the rest of the segment.
+func (seg *boundarySegment) Close() error {
+	s := seg.s
+	for !seg.done && s.current == seg {
+		if len(s.chunk) == 0 {
+			if s.err != nil {
+				break
+			}
+			s.next()
+			continue
+		}
+		seg.done = s.isSep
+		s.chunk = nil
+	}
+	seg.done = true
+	return nil
+}
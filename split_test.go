@@ -0,0 +1,70 @@
+package streamtools
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+func TestSplitOnBoundary(t *testing.T) {
+	for idx, test := range []struct {
+		in       []string
+		sep      string
+		handling SeparatorHandling
+		out      []string
+	}{
+		{[]string{"a--b", "-", "-c--"}, "--", DropSeparator, []string{"a", "b", "c"}},
+		{[]string{"a--b", "-", "-c--"}, "--", KeepSeparator, []string{"a--", "b--", "c--"}},
+		{[]string{"--a----b"}, "--", DropSeparator, []string{"", "a", "", "b"}},
+		{[]string{"a-", "b-"}, "--", DropSeparator, []string{"a-b-"}},
+		{[]string{}, "--", DropSeparator, nil},
+		{[]string{"a--b"}, "", DropSeparator, []string{"a--b"}},
+	} {
+		var out []string
+		for seg := range SplitOnBoundary(streamtest.NewChunkReader(test.in...), test.sep, test.handling) {
+			data, err := io.ReadAll(seg)
+			if err != nil {
+				t.Fatalf("case %d: unexpected error: %v", idx, err)
+			}
+			out = append(out, string(data))
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("case %d: got %q, want %q", idx, out, test.out)
+		}
+	}
+}
+
+func TestSplitOnBoundarySkipping(t *testing.T) {
+	// Segments that are not read, or only partly read, are skipped.
+	src := streamtest.NewChunkReader("first\n\nsecond document\n\nthird")
+	var out []string
+	for seg := range SplitOnBoundary(src, "\n\n", DropSeparator) {
+		buf := make([]byte, 3)
+		n, _ := seg.Read(buf)
+		out = append(out, string(buf[:n]))
+		if len(out) == 2 {
+			seg.Close()
+			if n, err := seg.Read(buf); n != 0 || err != io.EOF {
+				t.Fatalf("Read after Close = %d, %v", n, err)
+			}
+		}
+	}
+	if !reflect.DeepEqual(out, []string{"fir", "sec", "thi"}) {
+		t.Fatalf("got %q", out)
+	}
+
+	failure := errors.New("failure")
+	src = streamtest.NewChunkReader("a;b")
+	src.TerminalErr = failure
+	var errs []error
+	for seg := range SplitOnBoundary(src, ";", DropSeparator) {
+		_, err := io.ReadAll(seg)
+		errs = append(errs, err)
+	}
+	if !reflect.DeepEqual(errs, []error{nil, failure}) {
+		t.Fatalf("got errors %v", errs)
+	}
+}
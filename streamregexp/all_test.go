@@ -5,10 +5,12 @@
 package streamregexp
 
 import (
+	"io"
 	"reflect"
 	"regexp/syntax"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"unicode/utf8"
 )
 
@@ -532,6 +534,27 @@ func TestSplit(t *testing.T) {
 	}
 }
 
+func TestSplitReader(t *testing.T) {
+	for i, test := range splitTests {
+		re := MustCompile(test.r)
+		split := []string{}
+		if test.out == nil {
+			split = nil
+		}
+		r := iotest.HalfReader(strings.NewReader(test.s))
+		for segment := range re.SplitReader(r, test.n) {
+			b, err := io.ReadAll(segment)
+			if err != nil {
+				t.Fatalf("#%d: unexpected error: %v", i, err)
+			}
+			split = append(split, string(b))
+		}
+		if !reflect.DeepEqual(split, test.out) {
+			t.Errorf("#%d: %q: got %q; want %q", i, test.r, split, test.out)
+		}
+	}
+}
+
 // The following sequence of Match calls used to panic. See issue #12980.
 func TestParseAndCompile(t *testing.T) {
 	expr := "a$"
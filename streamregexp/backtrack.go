@@ -35,6 +35,7 @@ const (
 
 // bitState holds state for the backtracker.
 type bitState struct {
+	base     int
 	end      int
 	cap      []int
 	matchcap []int
@@ -75,9 +76,10 @@ func shouldBacktrack(prog *syntax.Prog) bool {
 }
 
 // reset resets the state of the backtracker.
-// end is the end position in the input.
+// base and end are the start and end positions in the input.
 // ncap is the number of captures.
-func (b *bitState) reset(prog *syntax.Prog, end int, ncap int) {
+func (b *bitState) reset(prog *syntax.Prog, base, end int, ncap int) {
+	b.base = base
 	b.end = end
 
 	if cap(b.jobs) == 0 {
@@ -86,7 +88,7 @@ func (b *bitState) reset(prog *syntax.Prog, end int, ncap int) {
 		b.jobs = b.jobs[:0]
 	}
 
-	visitedSize := (len(prog.Inst)*(end+1) + visitedBits - 1) / visitedBits
+	visitedSize := (len(prog.Inst)*(end-base+1) + visitedBits - 1) / visitedBits
 	if cap(b.visited) < visitedSize {
 		b.visited = make([]uint32, visitedSize, maxBacktrackVector/visitedBits)
 	} else {
@@ -118,7 +120,7 @@ func (b *bitState) reset(prog *syntax.Prog, end int, ncap int) {
 // shouldVisit reports whether the combination of (pc, pos) has not
 // been visited yet.
 func (b *bitState) shouldVisit(pc uint32, pos int) bool {
-	n := uint(int(pc)*(b.end+1) + pos)
+	n := uint(int(pc)*(b.end-b.base+1) + pos - b.base)
 	if b.visited[n/visitedBits]&(1<<(n&(visitedBits-1))) != 0 {
 		return false
 	}
@@ -305,6 +307,17 @@ func (re *Regexp) tryBacktrack(b *bitState, i input, pc uint32, pos int) bool {
 
 // backtrack runs a backtracking search of prog on the input starting at pos.
 func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []int) []int {
+	b := newBitState()
+	i, end := b.inputs.init(nil, ib, is)
+	dstCap = re.backtrackInput(b, i, 0, end, pos, ncap, dstCap)
+	freeBitState(b)
+	return dstCap
+}
+
+// backtrackInput runs a backtracking search of prog on the part of the
+// input between base and end, which must be all of the input from base
+// on, starting at pos.
+func (re *Regexp) backtrackInput(b *bitState, i input, base, end, pos int, ncap int, dstCap []int) []int {
 	startCond := re.cond
 	if startCond == ^syntax.EmptyOp(0) { // impossible
 		return nil
@@ -314,9 +327,7 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 		return nil
 	}
 
-	b := newBitState()
-	i, end := b.inputs.init(nil, ib, is)
-	b.reset(re.prog, end, ncap)
+	b.reset(re.prog, base, end, ncap)
 
 	// Anchored search must start at the beginning of the input
 	if startCond&syntax.EmptyBeginText != 0 {
@@ -324,7 +335,6 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 			b.cap[0] = pos
 		}
 		if !re.tryBacktrack(b, i, uint32(re.prog.Start), pos) {
-			freeBitState(b)
 			return nil
 		}
 	} else {
@@ -337,11 +347,10 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 		// so no work is duplicated and it ends up still being linear.
 		width := -1
 		for ; pos <= end && width != 0; pos += width {
-			if len(re.prefix) > 0 {
+			if re.prefixes != nil {
 				// Match requires literal prefix; fast search for it.
 				advance := i.index(re, pos)
 				if advance < 0 {
-					freeBitState(b)
 					return nil
 				}
 				pos += advance
@@ -356,12 +365,9 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 			}
 			_, width = i.step(pos)
 		}
-		freeBitState(b)
 		return nil
 	}
 
 Match:
-	dstCap = append(dstCap, b.matchcap...)
-	freeBitState(b)
-	return dstCap
+	return append(dstCap, b.matchcap...)
 }
@@ -0,0 +1,507 @@
+package streamregexp
+
+import (
+	"encoding/binary"
+	"regexp/syntax"
+	"slices"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file contains a lazily-built DFA, in the style of RE2's, for
+// searches that only need to know whether there is a match, and where
+// the first match to be completed ends.
+//
+// A DFA state is the set of instructions the NFA would be waiting to run
+// at a position, along with what it needs to know about the rune before
+// the position to evaluate empty-width assertions. States and the
+// transitions between them are built as the input calls for them and
+// cached, so a long input is scanned at the cost of a lookup per rune.
+//
+// The cache is bounded. When it fills up it is thrown away and rebuilt;
+// if that happens too often for the cache to be paying for itself, the
+// search carries on without caching, which is simply simulating the NFA.
+
+const (
+	// dfaCacheBudget is the approximate number of bytes the states
+	// of a DFA may occupy before the cache is reset.
+	dfaCacheBudget = 1 << 20
+
+	// dfaMinPosPerState is how many positions, on average, each state
+	// built since the last reset must have been used for to justify
+	// another reset, rather than giving up on the cache.
+	dfaMinPosPerState = 10
+
+	// dfaStateCost is the approximate size of a state, not counting its
+	// instructions and transitions.
+	dfaStateCost = 128
+)
+
+// A dfaState is a state of the lazy DFA.
+type dfaState struct {
+	// pcs are the instructions waiting to run, before following
+	// empty-width instructions, in increasing order.
+	pcs []uint32
+	// prev is a rune that stands in for the rune before the position
+	// when evaluating empty-width assertions.
+	prev rune
+	// start is whether the program's start instruction is also to be
+	// run at this position.
+	start bool
+	// matched holds, for the DFA of a Set, the patterns that matched
+	// at the position before the rune that led to the state, in
+	// increasing order.
+	matched []int32
+
+	// next caches the transitions out of the state, indexed by rune
+	// class, with the end of the text last.
+	next []*dfaState
+}
+
+var (
+	// dfaMatch is the transition to take when the state matches before
+	// consuming the rune.
+	dfaMatch = &dfaState{}
+	// dfaNoMatch is the transition at the end of the text when the
+	// state does not match.
+	dfaNoMatch = &dfaState{}
+)
+
+// idle reports whether no match can be in progress at the state. Any
+// match must start at or after the state's position.
+func (s *dfaState) idle() bool {
+	return len(s.pcs) == 0
+}
+
+// dead reports whether no match can be found from the state.
+func (s *dfaState) dead() bool {
+	return len(s.pcs) == 0 && !s.start
+}
+
+// prevClass returns the rune that stands in for r when it is the rune
+// before a position. Only whether r is the beginning of the text, a
+// newline or a word character matters.
+func prevClass(r rune) rune {
+	switch {
+	case r < 0:
+		return endOfText
+	case r == '\n':
+		return '\n'
+	case syntax.IsWordChar(r):
+		return 'a'
+	}
+	return ' '
+}
+
+// A lazyDFA holds the state cache and scratch space for running a DFA
+// for prog.
+type lazyDFA struct {
+	prog     *syntax.Prog
+	anchored bool
+	classes  *runeClasses
+	// patterns is set for the DFA of a Set. It maps the pc of the
+	// instruction that closes each pattern's group to one more than
+	// the pattern's index, and every other pc to 0. Matching a pattern
+	// does not end the search; it is recorded in the next state.
+	patterns []int32
+
+	states map[string]*dfaState
+	size   int
+	// posSinceReset counts the positions scanned since the cache was
+	// last reset.
+	posSinceReset int
+	uncached      bool
+
+	// scratch space for building states
+	set   sparseSet
+	next  sparseSet
+	stack []uint32
+	key   []byte
+	found []int32
+	// scratch state for running uncached
+	cur dfaState
+}
+
+var dfaPool sync.Pool
+
+// getDFA returns a DFA for re, reusing the cache of the last search with
+// re if possible.
+func (re *Regexp) getDFA() *lazyDFA {
+	return getDFA(re, re.cond&syntax.EmptyBeginText != 0, nil)
+}
+
+// getDFA returns a DFA for re, anchored at the position the search
+// starts at if anchored is set, and for a Set if patterns is set.
+func getDFA(re *Regexp, anchored bool, patterns []int32) *lazyDFA {
+	d, ok := dfaPool.Get().(*lazyDFA)
+	if !ok {
+		d = new(lazyDFA)
+	}
+	if d.prog != re.prog || d.anchored != anchored || (d.patterns == nil) != (patterns == nil) {
+		d.prog = re.prog
+		d.anchored = anchored
+		d.patterns = patterns
+		d.classes = re.runeClasses
+		d.reset()
+		d.cur.next = nil
+		d.set.init(len(re.prog.Inst))
+		d.next.init(len(re.prog.Inst))
+	}
+	d.posSinceReset = 0
+	d.uncached = false
+	return d
+}
+
+func putDFA(d *lazyDFA) {
+	dfaPool.Put(d)
+}
+
+func (d *lazyDFA) reset() {
+	if len(d.states) > 64 {
+		// Clearing a big map costs more than starting afresh.
+		d.states = nil
+	}
+	if d.states == nil {
+		d.states = map[string]*dfaState{}
+	}
+	clear(d.states)
+	d.size = 0
+}
+
+// search runs the DFA over i from pos. It reports whether there is a
+// match, the position the first match to be completed ends at, and the
+// last position before that at which no match was in progress; the
+// leftmost match starts at or after idle.
+//
+// If i is a window, it is told it can discard the text before idle as
+// the search goes.
+func (re *Regexp) dfaSearch(i input, pos int) (matched bool, end int, idle int) {
+	if re.cond == ^syntax.EmptyOp(0) { // impossible
+		return false, 0, pos
+	}
+	if re.cond&syntax.EmptyBeginText != 0 && pos != 0 {
+		return false, 0, pos
+	}
+
+	d := re.getDFA()
+	defer putDFA(d)
+
+	prev := rune(endOfText)
+	if pos != 0 {
+		prev = rune(i.context(pos) >> 32)
+	}
+	s := d.state(nil, nil, prevClass(prev), true)
+	r, width := i.step(pos)
+	idle = pos
+
+	w, _ := i.(*inputWindow)
+	nextDiscard := pos + windowReadSize
+	for {
+		if s.dead() {
+			return false, 0, idle
+		}
+		if s.idle() {
+			idle = pos
+			if w != nil && pos >= nextDiscard {
+				w.discard(pos)
+				nextDiscard = pos + windowReadSize
+			}
+			// A RuneReader is read a rune at a time either way,
+			// and the DFA is as quick as the search for the
+			// literals at that.
+			_, isReader := i.(*inputReader)
+			if re.prefixes != nil && !isReader && !re.prefixes.canStart(r) && i.canCheckPrefix() {
+				// Match requires literal prefix; fast search for it.
+				advance := i.index(re, pos)
+				if advance < 0 {
+					return false, 0, idle
+				}
+				pos += advance
+				idle = pos
+				s = d.state(nil, nil, prevClass(rune(i.context(pos)>>32)), true)
+				r, width = i.step(pos)
+			}
+		}
+
+		d.posSinceReset++
+		next := s.next[d.classes.of(r)]
+		if next == nil {
+			next = d.transition(s, r)
+		}
+		if next == dfaMatch {
+			return true, pos, idle
+		}
+		if width == 0 {
+			return false, 0, idle
+		}
+		s = next
+		pos += width
+		r, width = i.step(pos)
+	}
+}
+
+// state returns the state for the given instructions and matched
+// patterns, building it if needed. pcs and matched must be in increasing
+// order.
+func (d *lazyDFA) state(pcs []uint32, matched []int32, prev rune, start bool) *dfaState {
+	start = start || !d.anchored
+
+	if d.uncached {
+		// The state being left is no longer needed by now, even if
+		// it is the scratch state.
+		s := &d.cur
+		s.pcs = append(s.pcs[:0], pcs...)
+		s.matched = append(s.matched[:0], matched...)
+		s.prev, s.start = prev, start
+		if s.next == nil {
+			s.next = make([]*dfaState, d.classes.eot()+1)
+		}
+		return s
+	}
+
+	d.key = d.key[:0]
+	d.key = binary.AppendVarint(d.key, int64(prev))
+	if start {
+		d.key = append(d.key, 1)
+	} else {
+		d.key = append(d.key, 0)
+	}
+	if d.patterns != nil {
+		d.key = binary.AppendUvarint(d.key, uint64(len(matched)))
+		for _, k := range matched {
+			d.key = binary.AppendUvarint(d.key, uint64(k))
+		}
+	}
+	for _, pc := range pcs {
+		d.key = binary.AppendUvarint(d.key, uint64(pc))
+	}
+	if s, ok := d.states[string(d.key)]; ok {
+		return s
+	}
+
+	s := &dfaState{
+		pcs:     slices.Clone(pcs),
+		prev:    prev,
+		start:   start,
+		matched: slices.Clone(matched),
+		next:    make([]*dfaState, d.classes.eot()+1),
+	}
+	d.states[string(d.key)] = s
+	d.size += dfaStateCost + len(d.key) + 4*len(pcs) + 4*len(matched) + 8*len(s.next)
+	return s
+}
+
+// transition returns the state to move to from s on r, or dfaMatch if s
+// matches before r, building the transition if needed. The caller counts
+// the position in posSinceReset.
+func (d *lazyDFA) transition(s *dfaState, r rune) *dfaState {
+	class := d.classes.of(r)
+	if next := s.next[class]; next != nil {
+		return next
+	}
+
+	cache := !d.uncached
+	if cache && d.size > dfaCacheBudget {
+		if d.posSinceReset < dfaMinPosPerState*len(d.states) {
+			// The cache is not paying for itself.
+			d.uncached = true
+		} else {
+			d.reset()
+			d.posSinceReset = 0
+		}
+		// s is still in use, but it must let go of the states
+		// that were thrown away.
+		clear(s.next)
+		cache = false
+	}
+
+	next := d.build(s, r)
+	if cache {
+		s.next[class] = next
+	}
+	return next
+}
+
+// build computes the transition from s on r by simulating the NFA.
+func (d *lazyDFA) build(s *dfaState, r rune) *dfaState {
+	flag := newLazyFlag(s.prev, r)
+	d.set.clear()
+	d.next.clear()
+	d.found = d.found[:0]
+	if s.start {
+		d.follow(uint32(d.prog.Start), flag)
+	}
+	for _, pc := range s.pcs {
+		d.follow(pc, flag)
+	}
+
+	for _, pc := range d.set.dense {
+		i := &d.prog.Inst[pc]
+		add := false
+		switch i.Op {
+		case syntax.InstMatch:
+			if d.patterns == nil {
+				return dfaMatch
+			}
+		case syntax.InstCapture:
+			if d.patterns != nil && d.patterns[pc] > 0 {
+				d.found = append(d.found, d.patterns[pc]-1)
+			}
+		case syntax.InstRune:
+			add = i.MatchRune(r)
+		case syntax.InstRune1:
+			add = r == i.Rune[0]
+		case syntax.InstRuneAny:
+			add = true
+		case syntax.InstRuneAnyNotNL:
+			add = r != '\n'
+		}
+		if add && r != endOfText {
+			d.next.add(i.Out)
+		}
+	}
+	slices.Sort(d.found)
+	if r == endOfText {
+		if len(d.found) > 0 {
+			return d.state(nil, d.found, endOfText, false)
+		}
+		return dfaNoMatch
+	}
+
+	slices.Sort(d.next.dense)
+	return d.state(d.next.dense, d.found, prevClass(r), false)
+}
+
+// follow adds to d.set the instructions reachable from pc by following
+// the empty-width instructions satisfied by flag.
+func (d *lazyDFA) follow(pc uint32, flag lazyFlag) {
+	d.stack = append(d.stack[:0], pc)
+	for len(d.stack) > 0 {
+		pc = d.stack[len(d.stack)-1]
+		d.stack = d.stack[:len(d.stack)-1]
+		if pc == 0 || d.set.has(pc) {
+			continue
+		}
+		d.set.add(pc)
+
+		i := &d.prog.Inst[pc]
+		switch i.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			d.stack = append(d.stack, i.Arg, i.Out)
+		case syntax.InstEmptyWidth:
+			if flag.match(syntax.EmptyOp(i.Arg)) {
+				d.stack = append(d.stack, i.Out)
+			}
+		case syntax.InstNop, syntax.InstCapture:
+			d.stack = append(d.stack, i.Out)
+		}
+	}
+}
+
+// A sparseSet is a set of instruction pcs that can be cleared in
+// constant time. See queue.
+type sparseSet struct {
+	sparse []uint32
+	dense  []uint32
+}
+
+func (s *sparseSet) init(n int) {
+	if cap(s.sparse) < n {
+		s.sparse = make([]uint32, n)
+		s.dense = make([]uint32, 0, n)
+	}
+	s.sparse = s.sparse[:n]
+}
+
+func (s *sparseSet) has(pc uint32) bool {
+	j := s.sparse[pc]
+	return j < uint32(len(s.dense)) && s.dense[j] == pc
+}
+
+func (s *sparseSet) add(pc uint32) {
+	if !s.has(pc) {
+		s.sparse[pc] = uint32(len(s.dense))
+		s.dense = append(s.dense, pc)
+	}
+}
+
+func (s *sparseSet) clear() {
+	s.dense = s.dense[:0]
+}
+
+// runeClasses partitions the runes into classes of runes that a program
+// cannot tell apart, so the DFA only needs a transition for each class.
+type runeClasses struct {
+	// ascii holds the classes of the ASCII runes.
+	ascii [utf8.RuneSelf]int32
+	// starts holds the first rune of each class, in order.
+	starts []rune
+}
+
+// newRuneClasses computes the rune classes for prog.
+func newRuneClasses(prog *syntax.Prog) *runeClasses {
+	// The classes are delimited by every rune where a change in the
+	// rune may change the result of an instruction, or of an
+	// empty-width assertion about the runes on either side.
+	starts := []rune{0, '\n', '\n' + 1, '0', '9' + 1, 'A', 'Z' + 1, '_', '_' + 1, 'a', 'z' + 1}
+	for _, inst := range prog.Inst {
+		switch inst.Op {
+		case syntax.InstRune:
+			if len(inst.Rune) == 1 && syntax.Flags(inst.Arg)&syntax.FoldCase != 0 {
+				r0 := inst.Rune[0]
+				starts = append(starts, r0, r0+1)
+				for r := unicode.SimpleFold(r0); r != r0; r = unicode.SimpleFold(r) {
+					starts = append(starts, r, r+1)
+				}
+				continue
+			}
+			for j := 0; j+1 < len(inst.Rune); j += 2 {
+				starts = append(starts, inst.Rune[j], inst.Rune[j+1]+1)
+			}
+		case syntax.InstRune1:
+			starts = append(starts, inst.Rune[0], inst.Rune[0]+1)
+		}
+	}
+	slices.Sort(starts)
+	starts = slices.Compact(starts)
+	if starts[len(starts)-1] > unicode.MaxRune {
+		starts = starts[:len(starts)-1]
+	}
+
+	return runeClassesFrom(slices.Clip(starts))
+}
+
+// runeClassesFrom returns the rune classes starting at the given runes,
+// which must be in increasing order and start with 0.
+func runeClassesFrom(starts []rune) *runeClasses {
+	c := &runeClasses{starts: starts}
+	for r := range c.ascii {
+		c.ascii[r] = int32(c.search(rune(r)))
+	}
+	return c
+}
+
+func (c *runeClasses) search(r rune) int {
+	n, found := slices.BinarySearch(c.starts, r)
+	if !found {
+		n--
+	}
+	return n
+}
+
+// of returns the class of r.
+func (c *runeClasses) of(r rune) int {
+	if uint32(r) < utf8.RuneSelf {
+		return int(c.ascii[r])
+	}
+	if r == endOfText {
+		return c.eot()
+	}
+	return c.search(r)
+}
+
+// eot returns the class used for the end of the text.
+func (c *runeClasses) eot() int {
+	return len(c.starts)
+}
@@ -0,0 +1,97 @@
+package streamregexp
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDFA(t *testing.T) {
+	texts := []string{
+		"",
+		"x",
+		"foo bar\nbaz",
+		"STRASSE straße Straße",
+		"ΑΒΓ αβγ abc",
+		"line one\n\nline three\n",
+		"\xffbad\xfe utf8",
+		"kK K",
+	}
+	for _, expr := range []string{
+		``,
+		`$`,
+		`^`,
+		`\bba`,
+		`\Bar`,
+		`(?m)^line t`,
+		`(?m)^$`,
+		`(?m)e$`,
+		`(?i)straße`,
+		`(?i)k+`,
+		`\p{Greek}+ [a-c]`,
+		`[^\x00-\x7f]`,
+		`\x{fffd}`,
+		`^foo|baz$`,
+		`\Aline|three\n\z`,
+		`(a|b)*c`,
+		`o{2}`,
+		`[[:upper:]]{3}`,
+	} {
+		re := MustCompile(expr)
+		for _, text := range texts {
+			want := re.FindStringIndex(text)
+			matched, _, idle := re.dfaSearch(&inputString{text}, 0)
+			if matched != (want != nil) || matched && idle > want[0] {
+				t.Errorf("%#q on %#q: DFA found %v from %d, want %v",
+					expr, text, matched, idle, want)
+			}
+			if got := re.MatchReader(strings.NewReader(text)); got != (want != nil) {
+				t.Errorf("%#q on %#q: MatchReader = %v", expr, text, got)
+			}
+		}
+	}
+}
+
+func TestDFACacheThrash(t *testing.T) {
+	// The DFA for this needs a state for every combination of the
+	// last 15 characters, far more than the cache has room for.
+	re := MustCompile(`(a|b)*a(a|b){14}c`)
+	rng := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	for b.Len() < 1<<18 {
+		b.WriteByte("ab"[rng.Intn(2)])
+	}
+	text := b.String()
+
+	if re.MatchReader(strings.NewReader(text)) {
+		t.Fatalf("unexpected match")
+	}
+	text += "a" + strings.Repeat("b", 14) + "c"
+	if !re.MatchReader(strings.NewReader(text)) {
+		t.Fatalf("missed the match")
+	}
+	_, loc, _ := re.FindReaderRest(strings.NewReader(text))
+	if loc == nil {
+		t.Fatalf("missed the match")
+	}
+}
+
+func BenchmarkMatchReader(b *testing.B) {
+	for _, data := range benchData {
+		r := MustCompile(data.re)
+		for _, size := range benchSizes {
+			if size.n > 1<<20 {
+				continue
+			}
+			text := string(makeText(size.n))
+			b.Run(data.name+"/"+size.name, func(b *testing.B) {
+				b.SetBytes(int64(size.n))
+				for i := 0; i < b.N; i++ {
+					if r.MatchReader(strings.NewReader(text)) {
+						b.Fatal("match!")
+					}
+				}
+			})
+		}
+	}
+}
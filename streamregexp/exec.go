@@ -67,6 +67,9 @@ func (i *inputs) newReader(r io.RuneReader) input {
 	i.reader.r = r
 	i.reader.atEOT = false
 	i.reader.pos = 0
+	i.reader.last = [4]readerRune{}
+	i.reader.nlast = 0
+	i.reader.ahead = nil
 	return &i.reader
 }
 
@@ -194,6 +197,10 @@ func (m *machine) match(i input, pos int) bool {
 	} else {
 		flag = i.context(pos)
 	}
+	// A window needs to be told what it can let go of as the machine
+	// moves through it.
+	w, _ := i.(*inputWindow)
+	nextDiscard := pos + windowReadSize
 	for {
 		if len(runq.dense) == 0 {
 			if startCond&syntax.EmptyBeginText != 0 && pos != 0 {
@@ -204,7 +211,7 @@ func (m *machine) match(i input, pos int) bool {
 				// Have match; finished exploring alternatives.
 				break
 			}
-			if len(m.re.prefix) > 0 && r1 != m.re.prefixRune && i.canCheckPrefix() {
+			if m.re.prefixes != nil && !m.re.prefixes.canStart(r) && i.canCheckPrefix() {
 				// Match requires literal prefix; fast search for it.
 				advance := i.index(m.re, pos)
 				if advance < 0 {
@@ -222,6 +229,9 @@ func (m *machine) match(i input, pos int) bool {
 			m.add(runq, uint32(m.p.Start), pos, m.matchcap, &flag, nil)
 		}
 		flag = newLazyFlag(r, r1)
+		if w != nil && w.chunkEnd(pos+width) {
+			flag = newLazyFlag(r, endOfText)
+		}
 		m.step(runq, nextq, pos, pos+width, r, &flag)
 		if width == 0 {
 			break
@@ -237,11 +247,33 @@ func (m *machine) match(i input, pos int) bool {
 			r1, width1 = i.step(pos + width)
 		}
 		runq, nextq = nextq, runq
+		if w != nil && pos >= nextDiscard {
+			w.discard(m.earliest(runq, pos))
+			nextDiscard = pos + windowReadSize
+		}
 	}
 	m.clear(nextq)
 	return m.matched
 }
 
+// earliest returns the earliest position that the machine may still need
+// to report as part of a match, given the threads on q at pos.
+func (m *machine) earliest(q *queue, pos int) int {
+	if len(m.matchcap) == 0 {
+		return pos
+	}
+	earliest := pos
+	if m.matched && m.matchcap[0] < earliest {
+		earliest = m.matchcap[0]
+	}
+	for _, d := range q.dense {
+		if d.t != nil && d.t.cap[0] < earliest {
+			earliest = d.t.cap[0]
+		}
+	}
+	return earliest
+}
+
 // clear frees all threads on the thread queue.
 func (m *machine) clear(q *queue) {
 	for _, d := range q.dense {
@@ -395,12 +427,21 @@ func freeOnePassMachine(m *onePassMachine) {
 
 // doOnePass implements r.doExecute using the one-pass execution engine.
 func (re *Regexp) doOnePass(ir io.RuneReader, ib []byte, is string, pos, ncap int, dstCap []int) []int {
+	m := newOnePassMachine()
+	i, _ := m.inputs.init(ir, ib, is)
+	dstCap = re.onePass(m, i, pos, ncap, dstCap)
+	freeOnePassMachine(m)
+	return dstCap
+}
+
+// onePass runs the one-pass execution engine over the given input, which
+// need not be one of the machine's cached inputs.
+func (re *Regexp) onePass(m *onePassMachine, i input, pos, ncap int, dstCap []int) []int {
 	startCond := re.cond
 	if startCond == ^syntax.EmptyOp(0) { // impossible
 		return nil
 	}
 
-	m := newOnePassMachine()
 	if cap(m.matchcap) < ncap {
 		m.matchcap = make([]int, ncap)
 	} else {
@@ -412,8 +453,6 @@ func (re *Regexp) doOnePass(ir io.RuneReader, ib []byte, is string, pos, ncap in
 		m.matchcap[i] = -1
 	}
 
-	i, _ := m.inputs.init(ir, ib, is)
-
 	r, r1 := endOfText, endOfText
 	width, width1 := 0, 0
 	r, width = i.step(pos)
@@ -500,13 +539,10 @@ func (re *Regexp) doOnePass(ir io.RuneReader, ib []byte, is string, pos, ncap in
 
 Return:
 	if !matched {
-		freeOnePassMachine(m)
 		return nil
 	}
 
-	dstCap = append(dstCap, m.matchcap...)
-	freeOnePassMachine(m)
-	return dstCap
+	return append(dstCap, m.matchcap...)
 }
 
 // doMatch reports whether either r, b or s match the regexp.
@@ -538,6 +574,17 @@ func (re *Regexp) doExecute(r io.RuneReader, b []byte, s string, pos int, ncap i
 	m := re.get()
 	i, _ := m.inputs.init(r, b, s)
 
+	if ncap == 0 {
+		// Only whether there is a match matters, which the DFA can
+		// answer much faster.
+		matched, _, _ := re.dfaSearch(i, pos)
+		re.put(m)
+		if !matched {
+			return nil
+		}
+		return dstCap
+	}
+
 	m.init(ncap)
 	if !m.match(i, pos) {
 		re.put(m)
@@ -72,10 +72,15 @@ func testRE2(t *testing.T, file string) {
 	}
 	defer f.Close()
 	var txt io.Reader
+	// Checking the stream API against the exhaustive test takes several
+	// times longer than the rest of it put together, so only the search
+	// test does that.
+	checkStream := true
 	if strings.HasSuffix(file, ".bz2") {
 		z := bzip2.NewReader(f)
 		txt = z
 		file = file[:len(file)-len(".bz2")] // for error messages
+		checkStream = false
 	} else {
 		txt = f
 	}
@@ -173,6 +178,38 @@ func testRE2(t *testing.T, file string) {
 					}
 					continue
 				}
+				// run[i] has configured the regexp; the stream
+				// API must agree with the string API.
+				if checkStream {
+					streamRe := re
+					if i%2 == 0 {
+						streamRe = refull
+					}
+					if have := runStream(streamRe, text); !same(have, want) {
+						t.Errorf("%s:%d: %#q%s.FindReaderSubmatchRest(%#q) = %v, want %v", file, lineno, re, suffix, text, have, want)
+						if nfail++; nfail >= 100 {
+							t.Fatalf("stopping after %d errors", nfail)
+						}
+						continue
+					}
+					if have := streamRe.FindReaderSubmatchIndex(strings.NewReader(text)); !same(have, want) {
+						t.Errorf("%s:%d: %#q%s.FindReaderSubmatchIndex(%#q) = %v, want %v", file, lineno, re, suffix, text, have, want)
+						if nfail++; nfail >= 100 {
+							t.Fatalf("stopping after %d errors", nfail)
+						}
+						continue
+					}
+					// MatchReader reads through an inputReader,
+					// and goes to the DFA much as MatchString
+					// does.
+					if b := streamRe.MatchReader(strings.NewReader(text)); b != (want != nil) {
+						t.Errorf("%s:%d: %#q%s.MatchReader(%#q) = %v, want %v", file, lineno, re, suffix, text, b, !b)
+						if nfail++; nfail >= 100 {
+							t.Fatalf("stopping after %d errors", nfail)
+						}
+						continue
+					}
+				}
 				b, suffix := match[i](re, refull, text)
 				if b != (want != nil) {
 					t.Errorf("%s:%d: %#q%s.MatchString(%#q) = %v, want %v", file, lineno, re, suffix, text, b, !b)
@@ -181,6 +218,24 @@ func testRE2(t *testing.T, file string) {
 					}
 					continue
 				}
+				// The DFA must agree on whether there is a
+				// match, and not skip past its start. It
+				// pays no attention to longest, so there is
+				// no need to check it twice.
+				if i >= 2 {
+					continue
+				}
+				dfaRe := re
+				if i == 0 {
+					dfaRe = refull
+				}
+				if matched, idle := runDFA(dfaRe, text); matched != (want != nil) || matched && idle > want[0] {
+					t.Errorf("%s:%d: %#q%s DFA on %#q = %v at %d, want %v", file, lineno, re, suffix, text, matched, idle, want)
+					if nfail++; nfail >= 100 {
+						t.Fatalf("stopping after %d errors", nfail)
+					}
+					continue
+				}
 			}
 
 		default:
@@ -223,6 +278,16 @@ func runPartialLongest(re, refull *Regexp, text string) ([]int, string) {
 	return re.FindStringSubmatchIndex(text), "[longest]"
 }
 
+func runStream(re *Regexp, text string) []int {
+	_, loc, _ := re.FindReaderSubmatchRest(strings.NewReader(text))
+	return loc
+}
+
+func runDFA(re *Regexp, text string) (bool, int) {
+	matched, _, idle := re.dfaSearch(&inputString{text}, 0)
+	return matched, idle
+}
+
 var match = []func(*Regexp, *Regexp, string) (bool, string){
 	matchFull,
 	matchPartial,
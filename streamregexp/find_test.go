@@ -6,8 +6,11 @@ package streamregexp
 
 import (
 	"fmt"
+	"io"
+	"iter"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 // For each pattern/text pair, what is the expected output of each function?
@@ -235,6 +238,33 @@ func TestFindReaderIndex(t *testing.T) {
 	}
 }
 
+func TestFindReaderRest(t *testing.T) {
+	for _, test := range findTests {
+		for _, r := range []io.Reader{
+			strings.NewReader(test.text),
+			iotest.OneByteReader(strings.NewReader(test.text)),
+		} {
+			match, loc, rest := MustCompile(test.pat).FindReaderRest(r)
+			testFindIndex(&test, loc, t)
+			remainder, err := io.ReadAll(rest)
+			if err != nil {
+				t.Errorf("unexpected error reading rest: %v: %s", err, test)
+			}
+			if loc == nil {
+				continue
+			}
+			if string(match) != test.text[loc[0]:loc[1]] {
+				t.Errorf("expected match %q, got %q: %s",
+					test.text[loc[0]:loc[1]], match, test)
+			}
+			if string(remainder) != test.text[loc[1]:] {
+				t.Errorf("expected rest %q, got %q: %s",
+					test.text[loc[1]:], remainder, test)
+			}
+		}
+	}
+}
+
 // Now come the simple All cases.
 
 func TestFindAll(t *testing.T) {
@@ -446,6 +476,33 @@ func TestFindReaderSubmatchIndex(t *testing.T) {
 	}
 }
 
+func TestFindReaderSubmatchRest(t *testing.T) {
+	for _, test := range findTests {
+		r := iotest.OneByteReader(strings.NewReader(test.text))
+		submatch, loc, rest := MustCompile(test.pat).FindReaderSubmatchRest(r)
+		testFindSubmatchIndex(&test, loc, t)
+		if loc == nil {
+			continue
+		}
+		for i := range submatch {
+			if loc[2*i] < 0 {
+				if submatch[i] != nil {
+					t.Errorf("expected nil submatch %d: %s", i, test)
+				}
+				continue
+			}
+			if string(submatch[i]) != test.text[loc[2*i]:loc[2*i+1]] {
+				t.Errorf("wrong submatch %d %q: %s", i, submatch[i], test)
+			}
+		}
+		remainder, _ := io.ReadAll(rest)
+		if string(remainder) != test.text[loc[1]:] {
+			t.Errorf("expected rest %q, got %q: %s",
+				test.text[loc[1]:], remainder, test)
+		}
+	}
+}
+
 // Now come the monster AllSubmatch cases.
 
 func TestFindAllSubmatch(t *testing.T) {
@@ -516,3 +573,44 @@ func TestFindAllStringSubmatchIndex(t *testing.T) {
 		testFindAllSubmatchIndex(&test, MustCompile(test.pat).FindAllStringSubmatchIndex(test.text, -1), t)
 	}
 }
+
+// collectReaderMatches drains a FindAllReader iterator, checking the text
+// of each match against the index as it goes, since the text is only
+// valid during the iteration.
+func collectReaderMatches(test *FindTest, seq iter.Seq2[ReaderMatch, error], t *testing.T) [][]int {
+	var result [][]int
+	for match, err := range seq {
+		if err != nil {
+			t.Fatalf("unexpected error: %v: %s", err, test)
+		}
+		for k, text := range match.Submatch {
+			if match.Index[2*k] < 0 {
+				if text != nil {
+					t.Errorf("expected nil submatch %d: %s", k, test)
+				}
+				continue
+			}
+			if string(text) != test.text[match.Index[2*k]:match.Index[2*k+1]] {
+				t.Errorf("wrong submatch %d %q: %s", k, text, test)
+			}
+		}
+		result = append(result, match.Index)
+	}
+	return result
+}
+
+func TestFindAllReader(t *testing.T) {
+	for _, test := range findTests {
+		r := iotest.OneByteReader(strings.NewReader(test.text))
+		result := collectReaderMatches(&test, MustCompile(test.pat).FindAllReader(r, -1), t)
+		testFindAllIndex(&test, result, t)
+	}
+}
+
+func TestFindAllReaderSubmatch(t *testing.T) {
+	for _, test := range findTests {
+		r := iotest.OneByteReader(strings.NewReader(test.text))
+		result := collectReaderMatches(&test, MustCompile(test.pat).FindAllReaderSubmatch(r, -1), t)
+		testFindAllSubmatchIndex(&test, result, t)
+	}
+}
@@ -0,0 +1,211 @@
+package streamregexp
+
+import (
+	"io"
+	"iter"
+
+	"github.com/thejerf/streamtools"
+)
+
+// A MatchStart tag marks the start of a match found by a HighlightReader.
+// The bytes returned with it are the first bytes of the match.
+type MatchStart struct {
+	// Pattern is the index of the expression that matched, for a
+	// HighlightReader searching for a Set, and 0 otherwise.
+	Pattern int
+
+	// Index holds the byte offsets of the match and, for a
+	// HighlightReader searching for a Regexp, of its subexpressions,
+	// as ReaderMatch's Index does. Offsets are from the start of the
+	// stream.
+	Index []int
+}
+
+// Unwrap implements the Tag interface. MatchStart tags wrap nothing.
+func (ms MatchStart) Unwrap() []streamtools.Tag {
+	return nil
+}
+
+// A MatchEnd tag marks the end of a match found by a HighlightReader.
+// The bytes returned with it are the first bytes after the match. Its
+// fields are the same as those of the match's MatchStart.
+type MatchEnd struct {
+	Pattern int
+	Index   []int
+}
+
+// Unwrap implements the Tag interface. MatchEnd tags wrap nothing.
+func (me MatchEnd) Unwrap() []streamtools.Tag {
+	return nil
+}
+
+// HighlightReader passes a stream through unchanged, while marking where
+// the matches of a Regexp or a Set are in it with MatchStart and MatchEnd
+// tags, so that the matches can be highlighted as the stream is written
+// out. The matches are those FindAllReaderSubmatch, or a Set's
+// FindAllReader, would find.
+//
+// HighlightReader is a TaggedReader. A tag marks the position before the
+// bytes returned with it, so a Read never returns bytes from both sides
+// of a tag. When two tags mark the same position, as at the end of one
+// match and the start of the next, or around an empty match, the first is
+// returned on its own, with no bytes. A MatchEnd at the end of the stream
+// may come with io.EOF.
+//
+// Errors reading the stream, and from going over the Regexp's
+// StreamLimits, are returned once everything before them has been.
+//
+// A HighlightReader that is not read to the end should be closed, to
+// release the search. Close will also close the underlying reader if it
+// is an io.Closer.
+type HighlightReader struct {
+	r    io.Reader
+	next func() (highlightEvent, bool)
+	stop func()
+
+	pending []byte
+	// tag is a tag to return with the next Read.
+	tag streamtools.Tag
+	// rest is the rest of the stream, once there are no more matches.
+	rest io.Reader
+}
+
+// highlightEvent is one step of the search a HighlightReader performs:
+// some of the stream's data, a tag, or the rest of the stream after the
+// last match.
+type highlightEvent struct {
+	data []byte
+	tag  streamtools.Tag
+	rest io.Reader
+}
+
+// NewHighlightReader returns a HighlightReader marking the matches of re
+// in the stream read from src.
+func NewHighlightReader(src io.Reader, re *Regexp) *HighlightReader {
+	return newHighlightReader(src, func(w *inputWindow, start int, found func([]int, int) bool) {
+		re.windowMatches(w, start, -1, re.prog.NumCap, func(matches []int, err error) bool {
+			return err == nil && found(re.pad(matches), 0)
+		})
+	})
+}
+
+// NewSetHighlightReader returns a HighlightReader marking the matches of
+// the expressions in set in the stream read from src, tagging each with
+// the expression that matched.
+func NewSetHighlightReader(src io.Reader, set *Set) *HighlightReader {
+	return newHighlightReader(src, func(w *inputWindow, start int, found func([]int, int) bool) {
+		set.re.windowMatches(w, start, -1, 2, func(matches []int, err error) bool {
+			return err == nil && found(matches, set.patternAt(w, matches[0], matches[1]))
+		})
+	})
+}
+
+// newHighlightReader returns a HighlightReader for the matches that
+// search finds in the window, which it passes to found along with the
+// pattern that matched until found returns false.
+func newHighlightReader(src io.Reader, search func(w *inputWindow, start int, found func([]int, int) bool)) *HighlightReader {
+	w, start := windowFor(src)
+	next, stop := iter.Pull(highlightEvents(w, start, search))
+	return &HighlightReader{
+		r:    src,
+		next: next,
+		stop: stop,
+	}
+}
+
+// highlightEvents runs the search, yielding the data around and in the
+// matches as the search discards it, and the tags around the matches.
+func highlightEvents(w *inputWindow, start int, search func(w *inputWindow, start int, found func([]int, int) bool)) iter.Seq[highlightEvent] {
+	return func(yield func(highlightEvent) bool) {
+		stopped := false
+		w.flushed = start
+		w.flush = func(data []byte) bool {
+			stopped = !yield(highlightEvent{data: data})
+			return !stopped
+		}
+		defer func() { w.flush = nil }()
+
+		search(w, start, func(a []int, pattern int) bool {
+			if stopped {
+				return false
+			}
+			if a[0] > w.flushed && !yield(highlightEvent{data: w.bytes(w.flushed, a[0])}) {
+				stopped = true
+				return false
+			}
+			match := w.bytes(a[0], a[1])
+			w.flushed = a[1]
+			for j := range a {
+				if a[j] >= 0 {
+					a[j] -= start
+				}
+			}
+
+			stopped = !yield(highlightEvent{tag: MatchStart{pattern, a}}) ||
+				len(match) > 0 && !yield(highlightEvent{data: match}) ||
+				!yield(highlightEvent{tag: MatchEnd{pattern, a}})
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+
+		// The window must not flush into this coroutine once it has
+		// handed control back for good.
+		w.flush = nil
+		yield(highlightEvent{rest: &windowReader{w, w.flushed}})
+	}
+}
+
+// Read implements the TaggedReader interface.
+func (hr *HighlightReader) Read(buf []byte) (int, streamtools.Tag, error) {
+	tag := hr.tag
+	hr.tag = nil
+	for len(hr.pending) == 0 {
+		if hr.rest != nil {
+			n, err := hr.rest.Read(buf)
+			return n, tag, err
+		}
+
+		event, ok := hr.next()
+		switch {
+		case !ok:
+			// The search was stopped by Close.
+			hr.rest = eofReader{}
+		case event.rest != nil:
+			hr.rest = event.rest
+			hr.stop()
+		case event.tag != nil:
+			if tag != nil {
+				hr.tag = event.tag
+				return 0, tag, nil
+			}
+			tag = event.tag
+		default:
+			hr.pending = event.data
+		}
+	}
+
+	n := copy(buf, hr.pending)
+	hr.pending = hr.pending[n:]
+	return n, tag, nil
+}
+
+// Close stops the search, and will close the underlying reader if it is
+// an io.Closer.
+func (hr *HighlightReader) Close() error {
+	hr.stop()
+	hr.pending = nil
+	closer, isCloser := hr.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// eofReader is an io.Reader at the end of its stream.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
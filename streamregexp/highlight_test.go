@@ -0,0 +1,133 @@
+package streamregexp
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/thejerf/streamtools"
+)
+
+// highlightAll reads hr to the end with small reads, returning the
+// bytes, the MatchStart and MatchEnd tags in order, and the final error.
+// It checks that each tag comes at the position its Index gives.
+func highlightAll(t *testing.T, hr *HighlightReader) ([]byte, []streamtools.Tag, error) {
+	t.Helper()
+	var data []byte
+	var tags []streamtools.Tag
+	buf := make([]byte, 3)
+	for {
+		n, tag, err := hr.Read(buf)
+		switch tag := tag.(type) {
+		case MatchStart:
+			if tag.Index[0] != len(data) {
+				t.Errorf("MatchStart %v at %d", tag.Index, len(data))
+			}
+			tags = append(tags, tag)
+		case MatchEnd:
+			if tag.Index[1] != len(data) {
+				t.Errorf("MatchEnd %v at %d", tag.Index, len(data))
+			}
+			tags = append(tags, tag)
+		case nil:
+		default:
+			t.Fatalf("unexpected tag %#v", tag)
+		}
+		data = append(data, buf[:n]...)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return data, tags, err
+		}
+	}
+}
+
+func TestHighlightReader(t *testing.T) {
+	for _, expr := range []string{`a+`, `(\w+)@(\w+)?`, `x*`, `\b`, `$`, `b|c`, `zzz`} {
+		re := MustCompile(expr)
+		for _, text := range []string{"", "aaa", "bob@ al@home cab", "xxaxx", "abcbc zzz"} {
+			hr := NewHighlightReader(iotest.OneByteReader(strings.NewReader(text)), re)
+			data, tags, err := highlightAll(t, hr)
+			if err != nil {
+				t.Fatalf("%#q %q: unexpected error: %v", expr, text, err)
+			}
+			if string(data) != text {
+				t.Errorf("%#q %q: read %q", expr, text, data)
+			}
+
+			var want []streamtools.Tag
+			for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+				want = append(want, MatchStart{0, loc}, MatchEnd{0, loc})
+			}
+			if !reflect.DeepEqual(tags, want) {
+				t.Errorf("%#q %q: tags %v, want %v", expr, text, tags, want)
+			}
+		}
+	}
+}
+
+func TestSetHighlightReader(t *testing.T) {
+	set := MustCompileSet(setExprs)
+	for _, text := range setTexts {
+		hr := NewSetHighlightReader(iotest.HalfReader(strings.NewReader(text)), set)
+		data, tags, err := highlightAll(t, hr)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", text, err)
+		}
+		if string(data) != text {
+			t.Errorf("%q: read %q", text, data)
+		}
+
+		var want []streamtools.Tag
+		for match := range set.FindAllReader(strings.NewReader(text), -1) {
+			want = append(want, MatchStart{match.Pattern, match.Index}, MatchEnd{match.Pattern, match.Index})
+		}
+		if !reflect.DeepEqual(tags, want) {
+			t.Errorf("%q: tags %v, want %v", text, tags, want)
+		}
+	}
+}
+
+func TestHighlightReaderErrors(t *testing.T) {
+	failure := errors.New("failure")
+	r := io.MultiReader(strings.NewReader("abc def"), iotest.ErrReader(failure))
+	data, tags, err := highlightAll(t, NewHighlightReader(r, MustCompile(`b`)))
+	if err != failure || string(data) != "abc def" || len(tags) != 2 {
+		t.Fatalf("read %q, %v, %v", data, tags, err)
+	}
+
+	re := MustCompile(`a.*b`)
+	re.SetLimits(StreamLimits{MaxMatchLen: 10})
+	text := "xab" + strings.Repeat("a", 100000)
+	data, tags, err = highlightAll(t, NewHighlightReader(strings.NewReader(text), re))
+	if !isLimitError(err) || string(data) != text[:len(data)] || len(tags) != 0 {
+		t.Fatalf("read %d bytes, %v, %v", len(data), tags, err)
+	}
+
+	// Closing part way through stops the search and closes the source.
+	closer := &closeRecorder{Reader: strings.NewReader(strings.Repeat("ab", 10000))}
+	hr := NewHighlightReader(closer, MustCompile(`b`))
+	if _, _, err := hr.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hr.Close(); err != nil || !closer.closed {
+		t.Fatalf("Close = %v, closed %v", err, closer.closed)
+	}
+	if n, _, err := hr.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Fatalf("Read after Close = %d, %v", n, err)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
@@ -0,0 +1,263 @@
+package streamregexp
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file contains the literal prefix sets that let searches skip
+// ahead to where a match might start, without running the machines over
+// the text in between.
+
+const (
+	// maxPrefixSetSize is the largest number of literals a prefix set
+	// may hold before it is no longer worth searching for them.
+	maxPrefixSetSize = 16
+
+	// maxPrefixRunes is the longest a literal in a prefix set may be.
+	// Longer literals are cut short.
+	maxPrefixRunes = 32
+)
+
+// A literalSet is a set of literals, one of which every match must start
+// with.
+type literalSet struct {
+	strs  []string
+	bytes [][]byte
+	runes [][]rune
+	// first records the first bytes of the literals.
+	first [256]bool
+	// maxRunes is the length of the longest literal, in runes.
+	maxRunes int
+}
+
+// newLiteralSet returns a literalSet for the given literals, or nil if
+// they are not worth searching for.
+func newLiteralSet(strs []string) *literalSet {
+	if len(strs) == 0 || len(strs) > maxPrefixSetSize {
+		return nil
+	}
+	set := &literalSet{}
+	for _, s := range strs {
+		runes := []rune(s)
+		if len(runes) > maxPrefixRunes {
+			runes = runes[:maxPrefixRunes]
+			s = string(runes)
+		}
+		if len(runes) == 0 || slices.Contains(runes, utf8.RuneError) {
+			// Either anything can start a match, or an invalid
+			// byte could stand in for the literal.
+			return nil
+		}
+		if slices.Contains(set.strs, s) {
+			continue
+		}
+		set.strs = append(set.strs, s)
+		set.bytes = append(set.bytes, []byte(s))
+		set.runes = append(set.runes, runes)
+		set.first[s[0]] = true
+		set.maxRunes = max(set.maxRunes, len(runes))
+	}
+	return set
+}
+
+// literalPrefixes returns the set of literals one of which must start
+// any match of re, or nil if there is no such set worth having.
+func literalPrefixes(re *syntax.Regexp) *literalSet {
+	strs, _ := prefixLiterals(re)
+	return newLiteralSet(strs)
+}
+
+// prefixLiterals returns literals one of which must start any match of
+// re, and whether they are exactly the strings re matches, so more can be
+// added on to them. It returns nil if there are no such literals, which
+// includes when there are empty-width assertions before them, as the
+// searches that skip to the literals do not check the assertions.
+func prefixLiterals(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return []string{string(re.Rune)}, true
+		}
+		strs := []string{""}
+		for _, r0 := range re.Rune {
+			folds := []rune{r0}
+			for r := unicode.SimpleFold(r0); r != r0; r = unicode.SimpleFold(r) {
+				folds = append(folds, r)
+			}
+			if len(strs)*len(folds) > maxPrefixSetSize {
+				return strs, false
+			}
+			strs = crossLiterals(strs, runeStrings(folds))
+		}
+		return strs, true
+
+	case syntax.OpCharClass:
+		n := 0
+		for j := 0; j < len(re.Rune); j += 2 {
+			n += int(re.Rune[j+1]-re.Rune[j]) + 1
+			if n > maxPrefixSetSize {
+				return nil, false
+			}
+		}
+		runes := []rune{}
+		for j := 0; j < len(re.Rune); j += 2 {
+			for r := re.Rune[j]; r <= re.Rune[j+1]; r++ {
+				runes = append(runes, r)
+			}
+		}
+		return runeStrings(runes), true
+
+	case syntax.OpCapture:
+		return prefixLiterals(re.Sub[0])
+
+	case syntax.OpPlus:
+		strs, _ := prefixLiterals(re.Sub[0])
+		return strs, false
+
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return nil, false
+		}
+		strs, _ := prefixLiterals(re.Sub[0])
+		return strs, false
+
+	case syntax.OpAlternate:
+		var strs []string
+		exact := true
+		for _, sub := range re.Sub {
+			subStrs, subExact := prefixLiterals(sub)
+			if subStrs == nil {
+				return nil, false
+			}
+			strs = append(strs, subStrs...)
+			exact = exact && subExact
+		}
+		if len(strs) > maxPrefixSetSize {
+			return nil, false
+		}
+		return strs, exact
+
+	case syntax.OpConcat:
+		strs := []string{""}
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpEmptyMatch {
+				continue
+			}
+			subStrs, subExact := prefixLiterals(sub)
+			if subStrs == nil || len(strs)*len(subStrs) > maxPrefixSetSize {
+				if len(strs) == 1 && strs[0] == "" {
+					return nil, false
+				}
+				return strs, false
+			}
+			strs = crossLiterals(strs, subStrs)
+			if !subExact {
+				return strs, false
+			}
+		}
+		return strs, true
+	}
+	return nil, false
+}
+
+func runeStrings(runes []rune) []string {
+	strs := make([]string, len(runes))
+	for j, r := range runes {
+		strs[j] = string(r)
+	}
+	return strs
+}
+
+func crossLiterals(a, b []string) []string {
+	strs := make([]string, 0, len(a)*len(b))
+	for _, s := range a {
+		for _, t := range b {
+			strs = append(strs, s+t)
+		}
+	}
+	return strs
+}
+
+// canStart reports whether r is the first rune of one of the literals.
+func (set *literalSet) canStart(r rune) bool {
+	for _, runes := range set.runes {
+		if runes[0] == r {
+			return true
+		}
+	}
+	return false
+}
+
+// indexString returns the index of the first instance of any of the
+// literals in s, or -1.
+func (set *literalSet) indexString(s string) int {
+	if len(set.strs) == 1 {
+		return strings.Index(s, set.strs[0])
+	}
+	for j := 0; j < len(s); j++ {
+		if !set.first[s[j]] {
+			continue
+		}
+		for _, lit := range set.strs {
+			if strings.HasPrefix(s[j:], lit) {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// index returns the index of the first instance of any of the literals in
+// b, or -1.
+func (set *literalSet) index(b []byte) int {
+	if len(set.strs) == 1 {
+		return bytes.Index(b, set.bytes[0])
+	}
+	for j := 0; j < len(b); j++ {
+		if !set.first[b[j]] {
+			continue
+		}
+		for _, lit := range set.bytes {
+			if bytes.HasPrefix(b[j:], lit) {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// maxLen returns the length of the longest literal, in bytes.
+func (set *literalSet) maxLen() int {
+	n := 0
+	for _, lit := range set.strs {
+		n = max(n, len(lit))
+	}
+	return n
+}
+
+// endsWith reports whether the runes end with one of the literals.
+func (set *literalSet) endsWith(runes []readerRune) bool {
+	last := runes[len(runes)-1].r
+	for _, lit := range set.runes {
+		if len(lit) > len(runes) || lit[len(lit)-1] != last {
+			continue
+		}
+		tail := runes[len(runes)-len(lit):]
+		matched := true
+		for j, r := range lit {
+			if tail[j].r != r || tail[j].width != utf8.RuneLen(r) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
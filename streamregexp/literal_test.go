@@ -0,0 +1,152 @@
+package streamregexp
+
+import (
+	"reflect"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/thejerf/streamtools"
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+var prefixSetTests = []struct {
+	re   string
+	lits []string
+}{
+	{`abc`, []string{"abc"}},
+	{`foo|bar`, []string{"bar", "foo"}},
+	{`(?i)ab`, []string{"AB", "Ab", "aB", "ab"}},
+	{`(?i)k`, []string{"K", "k", "K"}},
+	{`[ab]c`, []string{"ac", "bc"}},
+	{`(foo)+x`, []string{"foo"}},
+	{`foo\bbar`, []string{"foo"}},
+	{`ab*c`, []string{"a"}},
+	{`(?:ab){2,}`, []string{"abab"}},
+	{`^foo`, nil},
+	{`\bfoo`, nil},
+	{`a*b`, nil},
+	{`x|`, nil},
+	{`[a-z]foo`, nil},
+	{`\x{fffd}x`, nil},
+	{`.foo`, nil},
+}
+
+func TestPrefixSets(t *testing.T) {
+	for _, test := range prefixSetTests {
+		re, err := syntax.Parse(test.re, syntax.Perl)
+		if err != nil {
+			t.Fatalf("%#q: %v", test.re, err)
+		}
+		var lits []string
+		if set := literalPrefixes(re.Simplify()); set != nil {
+			lits = slices.Sorted(slices.Values(set.strs))
+		}
+		if !reflect.DeepEqual(lits, test.lits) {
+			t.Errorf("%#q: prefixes %q, want %q", test.re, lits, test.lits)
+		}
+	}
+}
+
+func TestPrefixSetSearch(t *testing.T) {
+	// put the literals across the window's reads, and make them easy
+	// to almost match
+	filler := strings.Repeat("fo ba ", windowReadSize/6+1)
+	text := filler[:windowReadSize-2] + "foo1 " + filler + "bar22 FOO3" + filler + "fooX"
+
+	for _, expr := range []string{`(foo|bar)\d+`, `(?i)foo\d`, `foo\d|bar\d`, `o\d`} {
+		re := MustCompile(expr)
+		want := re.FindAllStringIndex(text, -1)
+
+		have := [][]int{}
+		for match, err := range re.FindAllReader(iotest.HalfReader(strings.NewReader(text)), -1) {
+			if err != nil {
+				t.Fatalf("%#q: unexpected error: %v", expr, err)
+			}
+			have = append(have, match.Index)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%#q: FindAllReader found %v, want %v", expr, have, want)
+		}
+
+		loc := re.FindReaderIndex(strings.NewReader(text))
+		if !reflect.DeepEqual(loc, want[0]) {
+			t.Errorf("%#q: FindReaderIndex found %v, want %v", expr, loc, want[0])
+		}
+		if !re.MatchReader(strings.NewReader(text)) {
+			t.Errorf("%#q: MatchReader found no match", expr)
+		}
+		if re.MatchReader(strings.NewReader(filler)) {
+			t.Errorf("%#q: MatchReader matched the filler", expr)
+		}
+	}
+}
+
+func TestPrefixSetContext(t *testing.T) {
+	// Searching a RuneReader discards the chunks it has passed over,
+	// but \b, ^ and $ must still see the runes either side of where
+	// they are checked, wherever the chunks split the stream.
+	filler := strings.Repeat("xfoo_", 1000)
+	for _, expr := range []string{`\bfoo`, `(?m)^foo`, `\Bfoo`, `(?m)^(foo|bar)\b`, `foo\b`, `(?m)foo$`, `\bfoo\b`} {
+		re := MustCompile(expr)
+		for _, tail := range []string{" foo", "\nfoo", "_foo", "\nbar ", "xfoo"} {
+			text := filler + tail
+			want := re.FindStringIndex(text)
+			for split := len(filler) - 2; split <= len(text); split++ {
+				chunks := []string{}
+				for start := 0; start < len(text); {
+					end := min(start+4, len(text))
+					if start < split && split < end {
+						end = split
+					}
+					chunks = append(chunks, text[start:end])
+					start = end
+				}
+				runes := func() *streamtools.RuneReader {
+					return streamtools.NewRuneReader(streamtest.NewChunkReader(chunks...), 8)
+				}
+				loc := re.FindReaderIndex(runes())
+				if !reflect.DeepEqual(loc, want) {
+					t.Errorf("%#q on %q split at %d: FindReaderIndex found %v, want %v", expr, tail, split, loc, want)
+				}
+				if re.MatchReader(runes()) != (want != nil) {
+					t.Errorf("%#q on %q split at %d: MatchReader disagrees with %v", expr, tail, split, want)
+				}
+			}
+		}
+	}
+}
+
+func TestInputReaderContextUnknown(t *testing.T) {
+	// Asked about a position it no longer holds, an inputReader takes
+	// the runes either side to be neither word characters nor newlines.
+	i := &inputReader{r: strings.NewReader("abcdefgh")}
+	for range 6 {
+		i.step(i.pos)
+	}
+	flag := i.context(1)
+	for _, op := range []syntax.EmptyOp{syntax.EmptyBeginLine, syntax.EmptyEndLine, syntax.EmptyBeginText, syntax.EmptyWordBoundary} {
+		if flag.match(op) {
+			t.Errorf("context matched %v", op)
+		}
+	}
+	if !flag.match(syntax.EmptyNoWordBoundary) {
+		t.Errorf("context did not match a non-word boundary")
+	}
+	if !i.context(0).match(syntax.EmptyBeginText) {
+		t.Errorf("context did not match the start of the text")
+	}
+}
+
+func BenchmarkFindAllReaderLiteral(b *testing.B) {
+	line := strings.Repeat("INFO everything is fine here\n", 99) + "ERROR 42 went wrong\n"
+	text := strings.Repeat(line, 1<<20/len(line))
+	re := MustCompile(`ERROR \d+`)
+	b.SetBytes(int64(len(text)))
+	for i := 0; i < b.N; i++ {
+		for range re.FindAllReader(strings.NewReader(text), -1) {
+		}
+	}
+}
@@ -0,0 +1,636 @@
+package streamregexp
+
+import (
+	"encoding/binary"
+	"errors"
+	"regexp/syntax"
+	"slices"
+	"unicode/utf8"
+)
+
+// This file contains the binary encoding of compiled regexps, which lets
+// a Regexp or a Set be loaded without parsing and compiling its
+// expressions again.
+//
+// The encoding starts with marshalMagic, which carries the version of the
+// format, and is followed by the fields of the Regexp as varints and
+// length-prefixed strings and slices. Lengths of slices are stored plus
+// one, so that a nil slice can be told apart from an empty one.
+
+const marshalMagic = "streamregexp\x01"
+
+// errBadEncoding is returned when loading a Regexp or Set from data that
+// is not a valid encoding of one.
+var errBadEncoding = errors.New("streamregexp: invalid encoding of a compiled regexp")
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding holds
+// the compiled form of the expression, along with the settings made by
+// Longest, SetEOTMode and SetLimits, so that UnmarshalBinary can load it
+// without compiling the expression again.
+//
+// The encoding is only meant to be read by this version of the package.
+func (re *Regexp) MarshalBinary() ([]byte, error) {
+	e := &encoder{buf: []byte(marshalMagic)}
+	e.regexp(re)
+	return e.buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It replaces re
+// with the Regexp encoded by MarshalBinary in data, returning an error if
+// data is not such an encoding.
+func (re *Regexp) UnmarshalBinary(data []byte) error {
+	d, err := newDecoder(data)
+	if err != nil {
+		return err
+	}
+	loaded := d.regexp()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*re = *loaded
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. Like a Regexp's, the
+// encoding holds the compiled form of the set, so that UnmarshalBinary
+// can load it without compiling the expressions again.
+func (s *Set) MarshalBinary() ([]byte, error) {
+	e := &encoder{buf: []byte(marshalMagic)}
+	e.uint(uint64(len(s.exprs)))
+	for _, expr := range s.exprs {
+		e.string(expr)
+	}
+	e.regexp(s.re)
+	for _, k := range s.patterns {
+		e.uint(uint64(k))
+	}
+	return e.buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It replaces s
+// with the Set encoded by MarshalBinary in data, returning an error if
+// data is not such an encoding.
+func (s *Set) UnmarshalBinary(data []byte) error {
+	d, err := newDecoder(data)
+	if err != nil {
+		return err
+	}
+	exprs := make([]string, d.count())
+	for k := range exprs {
+		exprs[k] = d.string()
+	}
+	re := d.regexp()
+	var patterns []int32
+	if d.err == nil {
+		patterns = make([]int32, len(re.prog.Inst))
+		for pc := range patterns {
+			if k := d.uint(); k <= uint64(len(exprs)) {
+				patterns[pc] = int32(k)
+			} else {
+				d.fail()
+			}
+		}
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*s = Set{exprs: exprs, re: re, patterns: patterns}
+	return nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) int(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint(1)
+	} else {
+		e.uint(0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// length records the length of a slice, and whether it is nil.
+func (e *encoder) length(n int, isNil bool) {
+	if isNil {
+		e.uint(0)
+	} else {
+		e.uint(uint64(n) + 1)
+	}
+}
+
+func (e *encoder) runes(runes []rune) {
+	e.length(len(runes), runes == nil)
+	for _, r := range runes {
+		e.int(int64(r))
+	}
+}
+
+func (e *encoder) inst(inst *syntax.Inst) {
+	e.uint(uint64(inst.Op))
+	e.uint(uint64(inst.Out))
+	e.uint(uint64(inst.Arg))
+	e.runes(inst.Rune)
+}
+
+func (e *encoder) regexp(re *Regexp) {
+	e.string(re.expr)
+
+	e.uint(uint64(re.prog.Start))
+	e.uint(uint64(re.prog.NumCap))
+	e.uint(uint64(len(re.prog.Inst)))
+	for j := range re.prog.Inst {
+		e.inst(&re.prog.Inst[j])
+	}
+
+	e.bool(re.onepass != nil)
+	if re.onepass != nil {
+		e.uint(uint64(re.onepass.Start))
+		e.uint(uint64(re.onepass.NumCap))
+		e.uint(uint64(len(re.onepass.Inst)))
+		for j := range re.onepass.Inst {
+			inst := &re.onepass.Inst[j]
+			e.inst(&inst.Inst)
+			e.length(len(inst.Next), inst.Next == nil)
+			for _, pc := range inst.Next {
+				e.uint(uint64(pc))
+			}
+		}
+	}
+
+	e.uint(uint64(re.numSubexp))
+	e.uint(uint64(re.maxBitStateLen))
+	e.length(len(re.subexpNames), re.subexpNames == nil)
+	for _, name := range re.subexpNames {
+		e.string(name)
+	}
+	e.string(re.prefix)
+	e.uint(uint64(re.prefixEnd))
+	e.uint(uint64(re.mpool))
+	e.uint(uint64(re.matchcap))
+	e.bool(re.prefixComplete)
+	e.uint(uint64(re.cond))
+	e.uint(uint64(re.minInputLen))
+	e.runes(re.runeClasses.starts)
+	e.bool(re.prefixes != nil)
+	if re.prefixes != nil {
+		e.uint(uint64(len(re.prefixes.strs)))
+		for _, lit := range re.prefixes.strs {
+			e.string(lit)
+		}
+	}
+
+	e.bool(re.longest)
+	e.uint(uint64(re.eotMode))
+	e.int(re.limits.MaxSteps)
+	e.int(int64(re.limits.MaxMatchLen))
+}
+
+// A decoder reads what an encoder wrote. Once it finds a problem with
+// the data, it records it in err and returns zero values from then on,
+// so the checking can be left to the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func newDecoder(data []byte) (*decoder, error) {
+	if len(data) < len(marshalMagic) || string(data[:len(marshalMagic)]) != marshalMagic {
+		return nil, errBadEncoding
+	}
+	return &decoder{data: data[len(marshalMagic):]}, nil
+}
+
+// finish returns the error for the data, if any, including if any of it
+// was left over.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail()
+	}
+	return d.err
+}
+
+func (d *decoder) fail() {
+	d.err = errBadEncoding
+	d.data = nil
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) bool() bool {
+	return d.uint() != 0
+}
+
+// count reads a count of things that each take up at least a byte of
+// the data, and so cannot be more than what is left of it.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// small reads a number that must be less than limit.
+func (d *decoder) small(limit int) int {
+	n := d.uint()
+	if n >= uint64(limit) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+// length reads the length of a slice, and whether it is nil.
+func (d *decoder) length() (int, bool) {
+	n := d.uint()
+	if n == 0 || n-1 > uint64(len(d.data)) {
+		if n != 0 {
+			d.fail()
+		}
+		return 0, true
+	}
+	return int(n - 1), false
+}
+
+func (d *decoder) runes() []rune {
+	n, isNil := d.length()
+	if isNil {
+		return nil
+	}
+	runes := make([]rune, n)
+	for j := range runes {
+		runes[j] = rune(d.int())
+	}
+	return runes
+}
+
+// inst reads an instruction of a program with n instructions and numCap
+// capture slots.
+func (d *decoder) inst(n, numCap int) syntax.Inst {
+	inst := syntax.Inst{
+		Op:  syntax.InstOp(d.small(int(syntax.InstRuneAnyNotNL) + 1)),
+		Out: uint32(d.small(n)),
+	}
+	switch inst.Op {
+	case syntax.InstAlt, syntax.InstAltMatch:
+		inst.Arg = uint32(d.small(n))
+	case syntax.InstCapture:
+		inst.Arg = uint32(d.small(numCap))
+	default:
+		inst.Arg = uint32(d.uint())
+	}
+	inst.Rune = d.runes()
+	if !validRunes(inst.Op, inst.Rune) {
+		d.fail()
+	}
+	return inst
+}
+
+// validRunes reports whether runes could be the runes of an instruction
+// with the given op: a single rune, as InstRune1 has and a case-folded
+// InstRune may, or else pairs of ranges in increasing order.
+func validRunes(op syntax.InstOp, runes []rune) bool {
+	switch {
+	case op == syntax.InstRune1:
+		return len(runes) == 1
+	case len(runes) == 1:
+		return op == syntax.InstRune
+	case len(runes)%2 != 0:
+		return false
+	}
+	for j := 0; j < len(runes); j += 2 {
+		if runes[j] > runes[j+1] || j > 0 && runes[j] <= runes[j-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *decoder) regexp() *Regexp {
+	re := &Regexp{expr: d.string()}
+
+	prog := &syntax.Prog{}
+	start := d.uint()
+	prog.NumCap = d.small(1 << 30)
+	prog.Inst = make([]syntax.Inst, d.count())
+	for j := range prog.Inst {
+		prog.Inst[j] = d.inst(len(prog.Inst), prog.NumCap)
+	}
+	if start >= uint64(len(prog.Inst)) {
+		d.fail()
+	}
+	prog.Start = int(start)
+	re.prog = prog
+
+	if d.bool() {
+		op := &onePassProg{}
+		start := d.uint()
+		op.NumCap = d.small(1 << 30)
+		op.Inst = make([]onePassInst, d.count())
+		for j := range op.Inst {
+			inst := &op.Inst[j]
+			inst.Inst = d.inst(len(op.Inst), op.NumCap)
+			n, isNil := d.length()
+			if !isNil {
+				inst.Next = make([]uint32, n)
+				for k := range inst.Next {
+					inst.Next[k] = uint32(d.small(len(op.Inst)))
+				}
+			}
+		}
+		if start >= uint64(len(op.Inst)) {
+			d.fail()
+		}
+		op.Start = int(start)
+		re.onepass = op
+	}
+
+	re.numSubexp = d.count()
+	re.maxBitStateLen = d.small(maxBacktrackVector + 1)
+	n, isNil := d.length()
+	if !isNil {
+		re.subexpNames = make([]string, n)
+		for j := range re.subexpNames {
+			re.subexpNames[j] = d.string()
+		}
+	}
+	re.prefix = d.string()
+	re.prefixEnd = uint32(d.uint())
+	re.mpool = d.small(len(matchSize))
+	re.matchcap = d.small(1 << 30)
+	re.prefixComplete = d.bool()
+	re.cond = syntax.EmptyOp(d.uint())
+	re.minInputLen = d.small(1 << 30)
+	starts := d.runes()
+	if len(starts) == 0 || starts[0] != 0 {
+		d.fail()
+	}
+	if d.bool() {
+		strs := make([]string, d.count())
+		for j := range strs {
+			strs[j] = d.string()
+		}
+		if re.prefixes = newLiteralSet(strs); re.prefixes == nil {
+			d.fail()
+		}
+	}
+
+	re.longest = d.bool()
+	re.eotMode = EOTMode(d.small(int(EOTChunk) + 1))
+	re.limits.MaxSteps = d.int()
+	re.limits.MaxMatchLen = int(d.int())
+
+	if d.err != nil {
+		return nil
+	}
+	if !re.validLoaded(starts) {
+		d.fail()
+		return nil
+	}
+	re.runeClasses = runeClassesFrom(starts)
+	if re.prefix != "" {
+		re.prefixBytes = []byte(re.prefix)
+		re.prefixRune, _ = utf8.DecodeRuneInString(re.prefix)
+	}
+	return re
+}
+
+// validLoaded reports whether a Regexp that has been decoded, with the
+// given rune class starts, is one that compiling an expression could
+// have produced, as far as is needed for the matchers to run on it
+// without going wrong. The fields that follow from the program are
+// checked against it.
+func (re *Regexp) validLoaded(starts []rune) bool {
+	prog := re.prog
+	if !validProg(prog) {
+		return false
+	}
+	maxBitState := 0
+	if re.onepass == nil {
+		maxBitState = maxBitStateLen(prog)
+	} else if !validOnePass(re.onepass, prog) || int(re.prefixEnd) >= len(re.onepass.Inst) {
+		return false
+	}
+	for j := 1; j < len(starts); j++ {
+		if starts[j] <= starts[j-1] {
+			return false
+		}
+	}
+	return len(re.subexpNames) == re.numSubexp+1 &&
+		prog.NumCap <= 2*(re.numSubexp+1) &&
+		re.matchcap == prog.NumCap &&
+		re.mpool == matchPoolFor(prog) &&
+		re.maxBitStateLen == maxBitState &&
+		re.cond == prog.StartCond()
+}
+
+// validProg reports whether prog can be run: it must start with the
+// InstFail that compiled programs have, and capture into slots counted by
+// NumCap, which is even, as the slots come in pairs. Then its paths must
+// be valid.
+func validProg(prog *syntax.Prog) bool {
+	// The captures were checked against NumCap by decoder.inst.
+	return prog.Inst[0].Op == syntax.InstFail &&
+		prog.NumCap >= 2 && prog.NumCap%2 == 0 &&
+		validPaths(prog.Inst, nil, prog.Start)
+}
+
+// validOnePass reports whether op is the one-pass form of prog. Apart
+// from its InstAlts, which are rewritten and given the runes that choose
+// between their branches, and the runes its other instructions are
+// given, it must be the same program, and its paths must be valid.
+func validOnePass(op *onePassProg, prog *syntax.Prog) bool {
+	if op.Start != prog.Start || op.NumCap != prog.NumCap || len(op.Inst) != len(prog.Inst) {
+		return false
+	}
+	insts := make([]syntax.Inst, len(op.Inst))
+	nexts := make([][]uint32, len(op.Inst))
+	for pc := range op.Inst {
+		inst, orig := &op.Inst[pc], &prog.Inst[pc]
+		insts[pc], nexts[pc] = inst.Inst, inst.Next
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			// An InstAlt may have become an InstAltMatch. It
+			// needs a branch for each of its ranges of runes.
+			if orig.Op != syntax.InstAlt && orig.Op != syntax.InstAltMatch ||
+				len(inst.Next) < (len(inst.Rune)+1)/2 {
+				return false
+			}
+			continue
+		}
+		if inst.Op != orig.Op || inst.Out != orig.Out || inst.Arg != orig.Arg {
+			return false
+		}
+		switch inst.Op {
+		case syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+			if inst.Next != nil || !slices.Equal(inst.Rune, orig.Rune) {
+				return false
+			}
+		}
+	}
+	return validPaths(insts, nexts, op.Start)
+}
+
+// validPaths reports whether the matchers can follow the paths through a
+// program with the given instructions, and for a one-pass program, the
+// Next of each. No path from start may run into InstFail, although start
+// can be InstFail itself, as it is for a Set of no expressions. No path
+// may reach InstMatch having started a group without ending it, which
+// would leave the group ending before it starts. And no loop may go
+// around without consuming a rune, except through an InstAlt, as the
+// matchers that follow many paths at once keep track of where they have
+// been; a one-pass program follows one path at a time, so it cannot have
+// such a loop at all.
+func validPaths(insts []syntax.Inst, nexts [][]uint32, start int) bool {
+	next := func(pc int) []uint32 {
+		inst := &insts[pc]
+		switch inst.Op {
+		case syntax.InstMatch, syntax.InstFail:
+			return nil
+		case syntax.InstAlt, syntax.InstAltMatch:
+			if nexts != nil {
+				return append([]uint32{inst.Out, inst.Arg}, nexts[pc]...)
+			}
+			return []uint32{inst.Out, inst.Arg}
+		}
+		return []uint32{inst.Out}
+	}
+	if hasLoop(len(insts), func(pc int) []uint32 {
+		switch insts[pc].Op {
+		case syntax.InstNop, syntax.InstCapture, syntax.InstEmptyWidth:
+			return next(pc)
+		case syntax.InstAlt, syntax.InstAltMatch:
+			if nexts != nil {
+				return next(pc)
+			}
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	// Follow the paths once for each group, tracking whether it has
+	// been started but not ended, and once more for no group at all, to
+	// check for InstFail when there are none.
+	groups := []int{-1}
+	for _, inst := range insts {
+		if inst.Op == syntax.InstCapture && !slices.Contains(groups, int(inst.Arg/2)) {
+			groups = append(groups, int(inst.Arg/2))
+		}
+	}
+	type state struct {
+		pc   int
+		open bool
+	}
+	for _, group := range groups {
+		seen := map[state]bool{{start, false}: true}
+		stack := []state{{start, false}}
+		for len(stack) > 0 {
+			s := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			inst := &insts[s.pc]
+			switch {
+			case inst.Op == syntax.InstMatch && s.open:
+				return false
+			case inst.Op == syntax.InstCapture && int(inst.Arg/2) == group:
+				s.open = inst.Arg%2 == 0
+			}
+			for _, pc := range next(s.pc) {
+				if insts[pc].Op == syntax.InstFail {
+					return false
+				}
+				if to := (state{int(pc), s.open}); !seen[to] {
+					seen[to] = true
+					stack = append(stack, to)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// hasLoop reports whether there is a loop in a program of n
+// instructions, following the edges next returns for each instruction.
+func hasLoop(n int, next func(pc int) []uint32) bool {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]uint8, n)
+	type frame struct {
+		pc   int
+		next []uint32
+	}
+	for start := range n {
+		if state[start] != unvisited {
+			continue
+		}
+		state[start] = visiting
+		stack := []frame{{start, next(start)}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if len(top.next) == 0 {
+				state[top.pc] = done
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			pc := int(top.next[0])
+			top.next = top.next[1:]
+			switch state[pc] {
+			case visiting:
+				return true
+			case unvisited:
+				state[pc] = visiting
+				stack = append(stack, frame{pc, next(pc)})
+			}
+		}
+	}
+	return false
+}
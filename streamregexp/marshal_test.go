@@ -0,0 +1,176 @@
+package streamregexp
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	exprs := append([]string{
+		`^(?P<word>\w+)$`,
+		`(?i)foo|bar|straße`,
+		`a+b+c`,
+		`(a|ab)(c|bcd)(d*)`,
+		`[^\x00-\x{10FFFF}]`,
+		// Groups that are simplified away take no capture slots.
+		`(a){0}(b){0}c`,
+	}, goodRe...)
+	for _, expr := range exprs {
+		re := MustCompile(expr)
+		for _, configure := range []func(*Regexp){
+			func(*Regexp) {},
+			func(re *Regexp) {
+				re.Longest()
+				re.SetEOTMode(EOTChunk)
+				re.SetLimits(StreamLimits{MaxSteps: 1000, MaxMatchLen: 10})
+			},
+			func(re *Regexp) {
+				// Negative limits are no limits.
+				re.SetLimits(StreamLimits{MaxSteps: -1, MaxMatchLen: -1})
+			},
+		} {
+			configure(re)
+			data, err := re.MarshalBinary()
+			if err != nil {
+				t.Fatalf("%#q: unexpected error: %v", expr, err)
+			}
+			loaded := new(Regexp)
+			if err := loaded.UnmarshalBinary(data); err != nil {
+				t.Fatalf("%#q: unexpected error: %v", expr, err)
+			}
+			if !reflect.DeepEqual(loaded, re) {
+				t.Errorf("%#q: loaded a different Regexp", expr)
+			}
+		}
+	}
+}
+
+func TestMarshalBinarySet(t *testing.T) {
+	set := MustCompileSet(setExprs)
+	data, err := set.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded := new(Set)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("loaded a different Set")
+	}
+	for _, text := range setTexts {
+		have, _ := loaded.MatchReader(strings.NewReader(text))
+		want, _ := set.MatchReader(strings.NewReader(text))
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%q: loaded Set matched %v, want %v", text, have, want)
+		}
+	}
+
+	// A Regexp cannot be loaded from a Set's encoding.
+	if err := new(Regexp).UnmarshalBinary(data); err == nil {
+		t.Errorf("loaded a Regexp from a Set")
+	}
+}
+
+func TestUnmarshalBinaryCorrupt(t *testing.T) {
+	data, _ := MustCompile(`(?i)(\w+)@(foo|bar)\.com\b`).MarshalBinary()
+	for n := range len(data) {
+		if err := new(Regexp).UnmarshalBinary(data[:n]); err == nil {
+			t.Fatalf("loaded a Regexp from %d of %d bytes", n, len(data))
+		}
+	}
+
+	// Damaged data may happen to decode, but what it decodes to must
+	// run without panicking.
+	rng := rand.New(rand.NewSource(1))
+	for _, data := range marshalSeeds(t) {
+		for range 1000 {
+			damaged := append([]byte{}, data...)
+			j := len(marshalMagic) + rng.Intn(len(data)-len(marshalMagic))
+			damaged[j] ^= byte(1 << rng.Intn(8))
+			useLoaded(damaged)
+		}
+	}
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	for _, data := range marshalSeeds(f) {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		useLoaded(data)
+	})
+}
+
+// marshalSeeds returns encodings of Regexps that between them use all
+// of the matchers, and of a Set.
+func marshalSeeds(tb testing.TB) [][]byte {
+	seeds := [][]byte{}
+	for _, expr := range []string{
+		`(a+)(b|c)*d`,
+		`^(?P<word>\w+)$`,
+		`(?i)(\w+)@(foo|bar)\.com\b`,
+		`(?m)^x*[^\n]$|(\b\B)`,
+	} {
+		data, err := MustCompile(expr).MarshalBinary()
+		if err != nil {
+			tb.Fatalf("%#q: unexpected error: %v", expr, err)
+		}
+		seeds = append(seeds, data)
+	}
+	data, err := MustCompileSet(setExprs).MarshalBinary()
+	if err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+	return append(seeds, data)
+}
+
+// useLoaded loads a Regexp or a Set from data, and if it loads, runs it
+// over some text in each of the ways it can be run.
+func useLoaded(data []byte) {
+	texts := []string{"", "abd", "aabcbd Foo@BAR.com\nxx", strings.Repeat("ab", 100) + "cd\n"}
+	re := new(Regexp)
+	if re.UnmarshalBinary(data) == nil {
+		re.SetLimits(StreamLimits{MaxSteps: 100000})
+		for _, text := range texts {
+			re.MatchString(text)
+			re.FindStringSubmatchIndex(text)
+			re.FindAllStringSubmatchIndex(text, -1)
+			re.MatchReader(strings.NewReader(text))
+			re.FindReaderSubmatchIndex(strings.NewReader(text))
+			for _, err := range re.FindAllReaderSubmatch(strings.NewReader(text), -1) {
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+	set := new(Set)
+	if set.UnmarshalBinary(data) == nil {
+		for _, text := range texts {
+			set.MatchReader(strings.NewReader(text))
+			for _, err := range set.FindAllReader(strings.NewReader(text), -1) {
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	expr := `(?i)(\w+)@(` + strings.Repeat(`foo|bar|baz|`, 20) + `qux)\.(com|org|net)\b`
+	data, _ := MustCompile(expr).MarshalBinary()
+	b.Run("Compile", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			MustCompile(expr)
+		}
+	})
+	b.Run("UnmarshalBinary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			new(Regexp).UnmarshalBinary(data)
+		}
+	})
+}
@@ -66,6 +66,16 @@
 // match text from a RuneReader may read arbitrarily far into the input
 // before returning.
 //
+// The methods that match text from an io.Reader do not have that
+// problem, because they read the stream through a window that lets them
+// hand back whatever they read past the match:
+//
+//	FindReaderRest, FindReaderSubmatchRest
+//	FindAllReader, FindAllReaderSubmatch, SplitReader
+//
+// A Set matches many regular expressions against a stream in a single
+// pass, reporting which of them matched; see CompileSet.
+//
 // (There are a few other methods that do not match this pattern.)
 package streamregexp
 
@@ -73,6 +83,7 @@ import (
 	"bytes"
 	"io"
 	"regexp/syntax"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -99,10 +110,14 @@ type Regexp struct {
 	prefixComplete bool           // prefix is the entire regexp
 	cond           syntax.EmptyOp // empty-width conditions required at start of match
 	minInputLen    int            // minimum length of the input in bytes
+	runeClasses    *runeClasses   // rune classes for the DFA
+	prefixes       *literalSet    // literals that must start unanchored matches, or nil
 
-	// This field can be modified by the Longest method,
-	// but it is otherwise read-only.
-	longest bool // whether regexp prefers leftmost-longest match
+	// These fields can be modified by the Longest, SetEOTMode and
+	// SetLimits methods, but they are otherwise read-only.
+	longest bool         // whether regexp prefers leftmost-longest match
+	eotMode EOTMode      // where the end of the text is in a stream
+	limits  StreamLimits // limits on searches of streams
 }
 
 // String returns the source text used to compile the regular expression.
@@ -170,11 +185,82 @@ func (re *Regexp) Longest() {
 	re.longest = true
 }
 
+// An EOTMode says where the end of the text is, for the empty-width
+// assertions $, \z and \b, when matching against an io.Reader.
+type EOTMode int
+
+const (
+	// EOTStream, the default, puts the end of the text at the end of
+	// the stream. Deciding whether the assertions hold at the end of
+	// what has been read so far may need a rune from the next Read.
+	EOTStream EOTMode = iota
+
+	// EOTChunk puts an end of the text at the end of each chunk the
+	// stream returns from Read, as well as at the end of the stream, so
+	// that each chunk can be matched as it arrives. At the end of a
+	// chunk, $ and \z match, and \b matches after a word character,
+	// without waiting for the next Read. A match may still carry on
+	// past the end of a chunk into the next.
+	EOTChunk
+)
+
+// SetEOTMode sets where future searches of io.Readers put the end of the
+// text, as the FindReaderRest, FindAllReader and SplitReader families
+// see it. Searches of RuneReaders, which have no chunks, and of
+// in-memory text are not affected.
+// This method modifies the Regexp and may not be called concurrently
+// with any other methods.
+func (re *Regexp) SetEOTMode(mode EOTMode) {
+	re.eotMode = mode
+}
+
+// StreamLimits bounds the work searches of an io.Reader may do, so that
+// an expression, or a stream, from an untrusted source cannot tie up the
+// search indefinitely. A search that goes over a limit stops and reports
+// a streamtools.StreamError with the ErrorType
+// streamtools.ErrLimitExceeded, the way it reports an error reading the
+// stream: the FindAllReader family yields it as the final value, and the
+// readers the Rest methods and SplitReader return give it from Read.
+// Zero values, like negative ones, mean no limit.
+type StreamLimits struct {
+	// MaxSteps bounds the number of steps taken over a stream, which
+	// are roughly the runes the matching engines examine, or the bytes
+	// they skip over looking for a literal. The count is for the whole
+	// stream: all the matches an iterator finds, and any searches that
+	// continue on through the rest readers the Rest methods return.
+	MaxSteps int64
+
+	// MaxMatchLen bounds the length of a match in bytes. As the text of
+	// a match that may be in progress must be held on to, it also
+	// bounds the memory a search uses: a search stops once a match in
+	// progress has gone on for more than MaxMatchLen bytes, give or
+	// take the size of a read from the stream.
+	MaxMatchLen int
+}
+
+// SetLimits sets the limits on future searches of io.Readers by the
+// FindReaderRest, FindAllReader and SplitReader families. Searches of
+// RuneReaders and of in-memory text are not affected.
+// This method modifies the Regexp and may not be called concurrently
+// with any other methods.
+func (re *Regexp) SetLimits(limits StreamLimits) {
+	re.limits = StreamLimits{
+		MaxSteps:    max(limits.MaxSteps, 0),
+		MaxMatchLen: max(limits.MaxMatchLen, 0),
+	}
+}
+
 func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 	re, err := syntax.Parse(expr, mode)
 	if err != nil {
 		return nil, err
 	}
+	return compileSyntax(expr, re, longest)
+}
+
+// compileSyntax is compile for an expression that has already been
+// parsed.
+func compileSyntax(expr string, re *syntax.Regexp, longest bool) (*Regexp, error) {
 	maxCap := re.MaxCap()
 	capNames := re.CapNames()
 
@@ -197,10 +283,16 @@ func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 		longest:     longest,
 		matchcap:    matchcap,
 		minInputLen: minInputLen(re),
+		runeClasses: newRuneClasses(prog),
 	}
 	if regexp.onepass == nil {
 		regexp.prefix, regexp.prefixComplete = prog.Prefix()
 		regexp.maxBitStateLen = maxBitStateLen(prog)
+		if regexp.prefix != "" {
+			regexp.prefixes = newLiteralSet([]string{regexp.prefix})
+		} else {
+			regexp.prefixes = literalPrefixes(re)
+		}
 	} else {
 		regexp.prefix, regexp.prefixComplete, regexp.prefixEnd = onePassPrefix(prog)
 	}
@@ -211,14 +303,20 @@ func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 		regexp.prefixRune, _ = utf8.DecodeRuneInString(regexp.prefix)
 	}
 
+	regexp.mpool = matchPoolFor(prog)
+
+	return regexp, nil
+}
+
+// matchPoolFor returns the index of the pool of machines with queues
+// big enough for prog.
+func matchPoolFor(prog *syntax.Prog) int {
 	n := len(prog.Inst)
 	i := 0
 	for matchSize[i] != 0 && matchSize[i] < n {
 		i++
 	}
-	regexp.mpool = i
-
-	return regexp, nil
+	return i
 }
 
 // Pools of *machine for use during (*Regexp).doExecute,
@@ -373,6 +471,10 @@ func (re *Regexp) SubexpIndex(name string) int {
 
 const endOfText rune = -1
 
+// unknownRune stands in for a rune an input no longer holds. It is
+// neither a word character nor a newline, nor the end of the text.
+const unknownRune rune = utf8.RuneError
+
 // input abstracts different representations of the input text. It provides
 // one-character lookahead.
 type input interface {
@@ -408,6 +510,9 @@ func (i *inputString) hasPrefix(re *Regexp) bool {
 }
 
 func (i *inputString) index(re *Regexp, pos int) int {
+	if re.prefix == "" {
+		return re.prefixes.indexString(i.str[pos:])
+	}
 	return strings.Index(i.str[pos:], re.prefix)
 }
 
@@ -455,6 +560,9 @@ func (i *inputBytes) hasPrefix(re *Regexp) bool {
 }
 
 func (i *inputBytes) index(re *Regexp, pos int) int {
+	if re.prefix == "" {
+		return re.prefixes.index(i.str[pos:])
+	}
 	return bytes.Index(i.str[pos:], re.prefixBytes)
 }
 
@@ -482,6 +590,21 @@ type inputReader struct {
 	r     io.RuneReader
 	atEOT bool
 	pos   int
+	// last holds the last few runes returned by step, as a ring with
+	// nlast as its end; see back.
+	last  [4]readerRune
+	nlast uint
+	// ahead holds runes that have been read from r, but that step has
+	// yet to return; they start at pos.
+	ahead []readerRune
+	// scan is scratch space for index.
+	scan []readerRune
+}
+
+// A readerRune is a rune read from a RuneReader.
+type readerRune struct {
+	r     rune
+	width int
 }
 
 func (i *inputReader) step(pos int) (rune, int) {
@@ -489,29 +612,164 @@ func (i *inputReader) step(pos int) (rune, int) {
 		return endOfText, 0
 
 	}
-	r, w, err := i.r.ReadRune()
-	if err != nil {
-		i.atEOT = true
-		return endOfText, 0
+	var rr readerRune
+	if len(i.ahead) > 0 {
+		rr = i.ahead[0]
+		i.ahead = i.ahead[1:]
+	} else {
+		r, w, err := i.r.ReadRune()
+		if err != nil {
+			i.atEOT = true
+			return endOfText, 0
+		}
+		rr = readerRune{r, w}
+	}
+	i.pos += rr.width
+	i.last[i.nlast&3] = rr
+	i.nlast++
+	return rr.r, rr.width
+}
+
+// back returns the rune k runes back, where the last rune step returned is
+// 1 back, for k up to 4. A rune that is not known has no width.
+func (i *inputReader) back(k int) readerRune {
+	return i.last[(i.nlast-uint(k))&3]
+}
+
+// unread arranges for step to return the runes again before reading any
+// more. The runes must be the ones that immediately precede pos, and
+// before is the rune that precedes them.
+func (i *inputReader) unread(runes []readerRune, before readerRune) {
+	i.ahead = slices.Concat(runes, i.ahead)
+	for _, rr := range runes {
+		i.pos -= rr.width
 	}
-	i.pos += w
-	return r, w
+	i.last = [4]readerRune{before}
+	i.nlast = 1
+}
+
+// recent returns how many runes back pos is, and false if that is too
+// far back to know about.
+func (i *inputReader) recent(pos int) (int, bool) {
+	p, k := i.pos, 0
+	for p > pos && k < 3 {
+		k++
+		p -= i.back(k).width
+	}
+	return k, p == pos
+}
+
+// rewind arranges for step to return the runes from pos again, which
+// must be no further back than the last few runes step returned. It
+// returns false if it cannot.
+func (i *inputReader) rewind(pos int) bool {
+	k, ok := i.recent(pos)
+	if !ok {
+		return false
+	}
+	if k > 0 {
+		runes := make([]readerRune, k)
+		for j := range runes {
+			runes[j] = i.back(k - j)
+		}
+		i.unread(runes, i.back(k+1))
+	}
+	return true
 }
 
 func (i *inputReader) canCheckPrefix() bool {
-	return false
+	return true
 }
 
+// hasPrefix reports whether the input starts with re.prefix, consuming
+// the prefix if so. It must be called at the start of the input.
 func (i *inputReader) hasPrefix(re *Regexp) bool {
-	return false
+	if !i.rewind(0) {
+		return false
+	}
+	for _, want := range re.prefix {
+		r, w := i.step(i.pos)
+		if r != want || w != utf8.RuneLen(want) {
+			return false
+		}
+	}
+	return true
 }
 
+// index reads ahead to the first place at or after pos where one of the
+// literals that must start a match could start, and returns its offset
+// from pos, or -1 if there is none. The runes from there on are kept for
+// step to return again.
 func (i *inputReader) index(re *Regexp, pos int) int {
-	return -1
+	if !i.rewind(pos) {
+		return 0
+	}
+	before := i.back(1)
+	set := re.prefixes
+	scan := i.scan[:0]
+	start := pos
+	for {
+		r, w := i.step(i.pos)
+		if w == 0 {
+			i.scan = scan
+			return -1
+		}
+		scan = append(scan, readerRune{r, w})
+
+		found := set.endsWith(scan)
+		if !found && len(scan) < 4*set.maxRunes {
+			continue
+		}
+		// No literal that starts before the last maxRunes runes
+		// can end any later than here.
+		drop := max(0, len(scan)-set.maxRunes)
+		for _, rr := range scan[:drop] {
+			start += rr.width
+		}
+		if drop > 0 {
+			before = scan[drop-1]
+		}
+		scan = scan[:copy(scan, scan[drop:])]
+		if found {
+			i.unread(scan, before)
+			i.scan = scan[:0]
+			return start - pos
+		}
+	}
 }
 
+// context only knows about the last few runes step returned, which is
+// all it is asked about: at the start, and after finding a prefix, where
+// index keeps the rune before it. Should it be asked about a position it
+// no longer holds, it answers as though the runes either side were
+// neither word characters nor newlines, rather than guessing at them.
 func (i *inputReader) context(pos int) lazyFlag {
-	return 0 // not used
+	k, ok := i.recent(pos)
+	if !ok {
+		if pos == 0 {
+			return newLazyFlag(endOfText, unknownRune)
+		}
+		return newLazyFlag(unknownRune, unknownRune)
+	}
+	r1, r2 := endOfText, endOfText
+	if pos > 0 {
+		r1 = i.back(k + 1).r
+	}
+	if k > 0 {
+		return newLazyFlag(r1, i.back(k).r)
+	}
+	if len(i.ahead) == 0 && !i.atEOT {
+		r, w, err := i.r.ReadRune()
+		if err != nil {
+			i.atEOT = true
+		} else {
+			i.ahead = append(i.ahead, readerRune{r, w})
+		}
+	}
+	if len(i.ahead) > 0 {
+		r2 = i.ahead[0].r
+	}
+	return newLazyFlag(r1, r2)
 }
 
 // LiteralPrefix returns a literal string that must begin any match
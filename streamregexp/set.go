@@ -0,0 +1,235 @@
+package streamregexp
+
+import (
+	"io"
+	"iter"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// A Set is a set of regular expressions that are matched against a
+// stream together, in a single pass, however many of them there are.
+//
+// The expressions are compiled into one program, each in a group of its
+// own, so the Set can tell which of them matched. A Set is safe for
+// concurrent use by multiple goroutines.
+type Set struct {
+	exprs []string
+	re    *Regexp
+	// patterns maps the pc of the instruction that closes each
+	// expression's group to one more than the expression's index; see
+	// lazyDFA.
+	patterns []int32
+}
+
+// A SetMatch is a match found in a stream by a Set's FindAllReader,
+// tagged with the index of the expression that matched.
+type SetMatch struct {
+	// Pattern is the index of the expression that matched, in the
+	// order the expressions were passed to CompileSet.
+	Pattern int
+	ReaderMatch
+}
+
+// CompileSet parses the regular expressions and returns, if successful,
+// a Set that can be used to match them all against a stream at once.
+// Each expression has the syntax accepted by Compile.
+func CompileSet(exprs []string) (*Set, error) {
+	subs := make([]*syntax.Regexp, len(exprs))
+	groups := make([]int, len(exprs))
+	ncap := 0
+	for k, expr := range exprs {
+		re, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			return nil, err
+		}
+		// Each expression's groups follow the group holding it.
+		groups[k] = ncap + 1
+		maxCap := re.MaxCap()
+		shiftCaps(re, groups[k])
+		subs[k] = &syntax.Regexp{Op: syntax.OpCapture, Cap: groups[k], Sub: []*syntax.Regexp{re}}
+		ncap += 1 + maxCap
+	}
+
+	var all *syntax.Regexp
+	switch len(subs) {
+	case 0:
+		all = &syntax.Regexp{Op: syntax.OpNoMatch}
+	case 1:
+		all = subs[0]
+	default:
+		all = &syntax.Regexp{Op: syntax.OpAlternate, Sub: subs}
+	}
+	re, err := compileSyntax("("+strings.Join(exprs, ")|(")+")", all, false)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := make([]int32, len(re.prog.Inst))
+	for pc, inst := range re.prog.Inst {
+		if inst.Op != syntax.InstCapture || inst.Arg%2 == 0 {
+			continue
+		}
+		if k, found := slices.BinarySearch(groups, int(inst.Arg/2)); found {
+			patterns[pc] = int32(k) + 1
+		}
+	}
+
+	return &Set{
+		exprs:    slices.Clone(exprs),
+		re:       re,
+		patterns: patterns,
+	}, nil
+}
+
+// MustCompileSet is like CompileSet but panics if an expression cannot
+// be parsed. It simplifies safe initialization of global variables
+// holding sets.
+func MustCompileSet(exprs []string) *Set {
+	set, err := CompileSet(exprs)
+	if err != nil {
+		panic(`regexp: CompileSet: ` + err.Error())
+	}
+	return set
+}
+
+// shiftCaps renumbers the groups of re to start at first + 1.
+func shiftCaps(re *syntax.Regexp, first int) {
+	if re.Op == syntax.OpCapture {
+		re.Cap += first
+	}
+	for _, sub := range re.Sub {
+		shiftCaps(sub, first)
+	}
+}
+
+// Len returns the number of expressions in the set.
+func (s *Set) Len() int {
+	return len(s.exprs)
+}
+
+// Expr returns the source text of the expression with the given index.
+func (s *Set) Expr(k int) string {
+	return s.exprs[k]
+}
+
+// MatchReader reads the stream r to its end, or until every expression
+// has matched, and returns the indexes of the expressions that match
+// somewhere in it, in increasing order. Matches may overlap: every
+// expression that matches anywhere is reported.
+//
+// If reading r fails with an error other than io.EOF, MatchReader
+// returns the expressions that matched before the failure along with the
+// error.
+func (s *Set) MatchReader(r io.Reader) ([]int, error) {
+	w, start := windowFor(r)
+	seen := make([]bool, len(s.exprs))
+	matched := []int{}
+	s.scan(w, start, -1, false, func(found []int32) bool {
+		for _, k := range found {
+			if !seen[k] {
+				seen[k] = true
+				matched = append(matched, int(k))
+			}
+		}
+		return len(matched) < len(s.exprs)
+	})
+	slices.Sort(matched)
+
+	if len(matched) < len(s.exprs) && w.err != nil && w.err != io.EOF {
+		return matched, w.err
+	}
+	return matched, nil
+}
+
+// FindAllReader returns an iterator over the successive matches of the
+// set's expressions in the text read from r, as FindAllReader does for a
+// Regexp, tagging each match with the expression that matched. If n >= 0,
+// it stops after n matches.
+//
+// The matches do not overlap. Where several expressions match at the
+// leftmost position, the first of them in the set is preferred, as it
+// would be in an alternation of the expressions.
+func (s *Set) FindAllReader(r io.Reader, n int) iter.Seq2[SetMatch, error] {
+	return func(yield func(SetMatch, error) bool) {
+		w, start := windowFor(r)
+		s.re.windowMatches(w, start, n, 2, func(matches []int, err error) bool {
+			if err != nil {
+				return yield(SetMatch{Pattern: -1}, err)
+			}
+			k := s.patternAt(w, matches[0], matches[1])
+			return yield(SetMatch{k, readerMatch(w, start, matches)}, nil)
+		})
+	}
+}
+
+// patternAt returns the expression that the match from pos to end in i
+// is a match of. That is the first expression in the set that matches at
+// pos, and expressions before it match nowhere from pos, so it is the
+// first of the expressions that match from pos and end by end.
+func (s *Set) patternAt(i input, pos, end int) int {
+	pattern := len(s.exprs)
+	s.scan(i, pos, end, true, func(found []int32) bool {
+		pattern = min(pattern, int(found[0]))
+		return true
+	})
+	return pattern
+}
+
+// scan runs the set's DFA over i from pos, anchored there if anchored is
+// set. It calls found with the expressions that match up to each
+// position, until found returns false, no more matches can be found, or
+// it has passed end, if end >= 0.
+//
+// If i is a window and the scan is not anchored, the window is told it
+// can discard the text as the scan goes.
+func (s *Set) scan(i input, pos, end int, anchored bool, found func([]int32) bool) {
+	re := s.re
+	if re.cond == ^syntax.EmptyOp(0) { // impossible
+		return
+	}
+	if re.cond&syntax.EmptyBeginText != 0 {
+		if pos != 0 {
+			return
+		}
+		anchored = true
+	}
+
+	d := getDFA(re, anchored, s.patterns)
+	defer putDFA(d)
+
+	prev := rune(endOfText)
+	if pos != 0 {
+		prev = rune(i.context(pos) >> 32)
+	}
+	st := d.state(nil, nil, prevClass(prev), true)
+	r, width := i.step(pos)
+
+	w, _ := i.(*inputWindow)
+	if anchored {
+		w = nil
+	}
+	nextDiscard := pos + windowReadSize
+	for !st.dead() {
+		if w != nil && pos >= nextDiscard {
+			w.discard(pos)
+			nextDiscard = pos + windowReadSize
+		}
+
+		d.posSinceReset++
+		next := st.next[d.classes.of(r)]
+		if next == nil {
+			next = d.transition(st, r)
+		}
+		if len(next.matched) > 0 && !found(next.matched) {
+			return
+		}
+		if width == 0 || pos == end {
+			return
+		}
+		st = next
+		pos += width
+		r, width = i.step(pos)
+	}
+}
@@ -0,0 +1,141 @@
+package streamregexp
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var setExprs = []string{
+	`foo`,
+	`fo+bar`,
+	`(?i)BAR\b`,
+	`^line`,
+	`(\d+)-(\d+)`,
+	`x*`,
+	`\Qa(b\E`,
+	`(?m)end$`,
+	`z{3}$`,
+}
+
+var setTexts = []string{
+	"",
+	"foo",
+	"foobar",
+	"line one\nlast line end\nzzz",
+	"12-34 Bar a(b",
+	"nothing here",
+	"\xffoo foooobar",
+}
+
+func TestSetMatchReader(t *testing.T) {
+	set := MustCompileSet(setExprs)
+	for _, text := range setTexts {
+		want := []int{}
+		for k, expr := range setExprs {
+			if MustCompile(expr).MatchString(text) {
+				want = append(want, k)
+			}
+		}
+		have, err := set.MatchReader(iotest.OneByteReader(strings.NewReader(text)))
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", text, err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%q: MatchReader = %v, want %v", text, have, want)
+		}
+	}
+}
+
+func TestSetFindAllReader(t *testing.T) {
+	set := MustCompileSet(setExprs)
+	alternation := MustCompile("(" + strings.Join(setExprs, ")|(") + ")")
+	for _, text := range setTexts {
+		want := alternation.FindAllStringIndex(text, -1)
+		for match, err := range set.FindAllReader(iotest.HalfReader(strings.NewReader(text)), -1) {
+			if err != nil {
+				t.Fatalf("%q: unexpected error: %v", text, err)
+			}
+			if len(want) == 0 || !reflect.DeepEqual(match.Index, want[0]) {
+				t.Fatalf("%q: unexpected match %v, want %v", text, match.Index, want)
+			}
+			want = want[1:]
+
+			// The pattern must match there, and no earlier one may
+			// match at the start of the match.
+			loc := match.Index
+			re := MustCompile(set.Expr(match.Pattern))
+			if m := re.FindStringIndex(text[loc[0]:]); m == nil || m[0] != 0 {
+				t.Errorf("%q: %#q does not match at %d", text, set.Expr(match.Pattern), loc[0])
+			}
+			for k := range match.Pattern {
+				if m := MustCompile(setExprs[k]).FindStringIndex(text[loc[0]:]); m != nil && m[0] == 0 && !strings.HasPrefix(setExprs[k], "^") {
+					t.Errorf("%q: %#q also matches at %d", text, setExprs[k], loc[0])
+				}
+			}
+		}
+		if len(want) > 0 {
+			t.Errorf("%q: missed matches %v", text, want)
+		}
+	}
+}
+
+func TestSetManyPatterns(t *testing.T) {
+	exprs := []string{}
+	for k := range 300 {
+		exprs = append(exprs, `\bword`+strings.Repeat("x", k%7)+`\d{`+string(rune('1'+k%9))+`}\b`)
+	}
+	set := MustCompileSet(exprs)
+	text := strings.Repeat("words and other words ", 1000) + "wordxx123 wordxxxxxx1234567"
+
+	have, err := set.MatchReader(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int{}
+	for k, expr := range exprs {
+		if MustCompile(expr).MatchString(text) {
+			want = append(want, k)
+		}
+	}
+	if len(want) == 0 || !reflect.DeepEqual(have, want) {
+		t.Fatalf("MatchReader = %v, want %v", have, want)
+	}
+}
+
+func TestSetError(t *testing.T) {
+	if _, err := CompileSet([]string{`a`, `b(`}); err == nil {
+		t.Fatalf("expected an error for a bad expression")
+	}
+
+	failure := errors.New("failure")
+	set := MustCompileSet([]string{`abc`, `xyz`})
+	r := io.MultiReader(strings.NewReader("abc def"), iotest.ErrReader(failure))
+	have, err := set.MatchReader(r)
+	if err != failure || !reflect.DeepEqual(have, []int{0}) {
+		t.Fatalf("MatchReader = %v, %v", have, err)
+	}
+
+	r = io.MultiReader(strings.NewReader("abc def"), iotest.ErrReader(failure))
+	patterns := []int{}
+	for match, err := range set.FindAllReader(r, -1) {
+		if err != nil {
+			if err != failure || match.Pattern != -1 {
+				t.Fatalf("unexpected final value %v, %v", match, err)
+			}
+			patterns = append(patterns, -1)
+			continue
+		}
+		patterns = append(patterns, match.Pattern)
+	}
+	if !reflect.DeepEqual(patterns, []int{0, -1}) {
+		t.Fatalf("FindAllReader found patterns %v", patterns)
+	}
+
+	if have, _ := MustCompileSet(nil).MatchReader(strings.NewReader("abc")); len(have) != 0 {
+		t.Fatalf("empty set matched %v", have)
+	}
+}
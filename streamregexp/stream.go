@@ -0,0 +1,756 @@
+package streamregexp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/thejerf/streamtools"
+)
+
+// This file contains the support for matching against io.Readers, as
+// opposed to the io.RuneReaders supported by the original regexp API.
+// An io.RuneReader can only be consumed, so it is impossible to tell
+// how far past the match the machine has read. Reading the io.Reader
+// through a window of bytes allows the matching to look both behind
+// and ahead, and to hand the unconsumed part of the stream back.
+
+// windowReadSize is the size of the reads the window makes from its
+// source, and how often the machine reports what it still needs.
+const windowReadSize = 4096
+
+// errWindowAbandoned is the error left in a window when its flush has
+// stopped it from reading. Nothing should ever see it.
+var errWindowAbandoned = errors.New("streamregexp: window abandoned")
+
+// inputWindow scans an io.Reader through a sliding window of bytes.
+//
+// Positions are offsets into the stream from where the window was
+// created. The window holds buf, which starts at position base, and will
+// discard the bytes before keep the next time it needs room, except for
+// utf8.UTFMax bytes of lookbehind so the rune before keep can still be
+// examined for context.
+//
+// If flush is set, the bytes between flushed and keep are passed to it
+// before the window next reads, so the bytes can be used as they are
+// discarded. If flush returns false, the window stops reading.
+//
+// The window records where each read from its source ended in chunkEnds.
+// If chunked is set, context treats those positions as the end of the
+// text; see EOTChunk.
+//
+// If maxSteps or maxMatchLen are set, the window enforces the limits of
+// a StreamLimits, stopping with an ErrLimitExceeded error in err once it
+// goes over them.
+type inputWindow struct {
+	src  io.Reader
+	buf  []byte
+	base int
+	keep int
+	err  error
+
+	chunkEnds []int
+	chunked   bool
+
+	steps       int64
+	maxSteps    int64
+	maxMatchLen int
+	overLimit   bool
+
+	flush   func([]byte) bool
+	flushed int
+}
+
+func newInputWindow(src io.Reader) *inputWindow {
+	return &inputWindow{
+		src: src,
+		buf: make([]byte, 0, windowReadSize),
+	}
+}
+
+// fill reads more data into the window. It returns false if there is no
+// more data to be had.
+func (w *inputWindow) fill() bool {
+	if w.err != nil {
+		return false
+	}
+
+	if w.flush != nil && w.keep > w.flushed {
+		data := w.bytes(w.flushed, w.keep)
+		w.flushed = w.keep
+		if !w.flush(data) {
+			w.err = errWindowAbandoned
+			return false
+		}
+	}
+
+	if w.maxMatchLen > 0 && w.end()-w.keep > w.maxMatchLen+windowReadSize {
+		w.exceed("streamregexp: match in progress is longer than %d bytes", w.maxMatchLen)
+		return false
+	}
+
+	if drop := w.keep - utf8.UTFMax - w.base; drop > 0 {
+		w.buf = w.buf[:copy(w.buf, w.buf[drop:])]
+		w.base += drop
+		n := 0
+		for n < len(w.chunkEnds) && w.chunkEnds[n] < w.base {
+			n++
+		}
+		w.chunkEnds = w.chunkEnds[:copy(w.chunkEnds, w.chunkEnds[n:])]
+	}
+	if cap(w.buf)-len(w.buf) < windowReadSize {
+		newBuf := make([]byte, len(w.buf), 2*cap(w.buf)+windowReadSize)
+		copy(newBuf, w.buf)
+		w.buf = newBuf
+	}
+
+	n, err := w.src.Read(w.buf[len(w.buf):cap(w.buf)])
+	w.buf = w.buf[:len(w.buf)+n]
+	w.err = err
+	if n > 0 {
+		w.chunkEnds = append(w.chunkEnds, w.end())
+	}
+	return true
+}
+
+// spend counts n steps against the window's limit on them. It reports
+// false, stopping the window, if that takes it over the limit.
+func (w *inputWindow) spend(n int) bool {
+	w.steps += int64(n)
+	if w.steps <= w.maxSteps {
+		return true
+	}
+	w.exceed("streamregexp: search took more than %d steps", w.maxSteps)
+	return false
+}
+
+// exceed stops the window for going over one of its limits.
+func (w *inputWindow) exceed(format string, args ...any) {
+	if !w.overLimit {
+		w.overLimit = true
+		w.err = streamtools.NewLimitError(format, args...)
+	}
+}
+
+// chunkEnd reports whether a read from the source ended at pos, so that
+// it is to be treated as the end of the text. It is only ever true if the
+// window is chunked.
+func (w *inputWindow) chunkEnd(pos int) bool {
+	if !w.chunked {
+		return false
+	}
+	_, found := slices.BinarySearch(w.chunkEnds, pos)
+	return found
+}
+
+// discard indicates that nothing before pos will be needed again, except
+// as context.
+func (w *inputWindow) discard(pos int) {
+	if pos > w.keep {
+		w.keep = pos
+	}
+}
+
+// end returns the position just past the last byte currently in the
+// window.
+func (w *inputWindow) end() int {
+	return w.base + len(w.buf)
+}
+
+// bytes returns the bytes in the window between the given positions. The
+// slice is only valid until the window is next filled.
+func (w *inputWindow) bytes(start, end int) []byte {
+	return w.buf[start-w.base : end-w.base : end-w.base]
+}
+
+func (w *inputWindow) step(pos int) (rune, int) {
+	if w.maxSteps > 0 && !w.spend(1) {
+		return endOfText, 0
+	}
+	for !utf8.FullRune(w.buf[pos-w.base:]) && w.fill() {
+	}
+	off := pos - w.base
+	if off >= len(w.buf) {
+		return endOfText, 0
+	}
+	c := w.buf[off]
+	if c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeRune(w.buf[off:])
+}
+
+func (w *inputWindow) canCheckPrefix() bool {
+	return true
+}
+
+func (w *inputWindow) hasPrefix(re *Regexp) bool {
+	for w.end()-w.base < len(re.prefix) && w.fill() {
+	}
+	return bytes.HasPrefix(w.buf[-w.base:], re.prefixBytes)
+}
+
+// index searches the stream from pos for the literals that must start a
+// match. As nothing before the literals can be part of a match, the
+// window lets go of it as it searches.
+func (w *inputWindow) index(re *Regexp, pos int) int {
+	// A literal may be split across fills, so the search must go back
+	// over the end of the last one.
+	overlap := re.prefixes.maxLen() - 1
+	from := pos
+	for {
+		n := re.prefixes.index(w.buf[from-w.base:])
+		if w.maxSteps > 0 {
+			searched := n
+			if n < 0 {
+				searched = w.end() - from
+			}
+			if !w.spend(searched) {
+				return -1
+			}
+		}
+		if n >= 0 {
+			return from + n - pos
+		}
+		from = max(pos, w.end()-overlap)
+		w.discard(from)
+		if !w.fill() {
+			return -1
+		}
+	}
+}
+
+// holdsRest reports whether the window holds the rest of the stream from
+// pos, and it is shorter than n bytes. It reads more of the stream while
+// there is room in the window, but does not grow the window to find out.
+func (w *inputWindow) holdsRest(pos, n int) bool {
+	for w.err == nil && w.end()-pos < n && cap(w.buf)-len(w.buf) >= windowReadSize {
+		w.fill()
+	}
+	return w.err != nil && w.end()-pos < n
+}
+
+func (w *inputWindow) context(pos int) lazyFlag {
+	r1 := endOfText
+	if pos > 0 {
+		r1, _ = utf8.DecodeLastRune(w.buf[:pos-w.base])
+	}
+	r2 := endOfText
+	if !w.chunkEnd(pos) {
+		r2, _ = w.step(pos)
+	}
+	return newLazyFlag(r1, r2)
+}
+
+// windowReader is the io.Reader handed back by the Rest family of
+// methods. It reads the stream from pos onward, starting with whatever is
+// already in the window.
+type windowReader struct {
+	w   *inputWindow
+	pos int
+}
+
+// Read implements io.Reader.
+func (wr *windowReader) Read(buf []byte) (int, error) {
+	w := wr.w
+	if wr.pos < w.end() {
+		n := copy(buf, w.buf[wr.pos-w.base:])
+		wr.pos += n
+		w.discard(wr.pos)
+		return n, nil
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	// Nothing is buffered, so read straight into the caller's buffer,
+	// keeping only the lookbehind in the window.
+	n, err := w.src.Read(buf)
+	w.err = err
+	if n > 0 {
+		lookbehind := buf[:n]
+		if len(lookbehind) > utf8.UTFMax {
+			lookbehind = lookbehind[n-utf8.UTFMax:]
+		}
+		w.buf = append(w.buf[:0], lookbehind...)
+		wr.pos += n
+		w.base = wr.pos - len(lookbehind)
+		w.keep = wr.pos
+		w.chunkEnds = append(w.chunkEnds[:0], wr.pos)
+	}
+	if n == 0 {
+		return 0, err
+	}
+	return n, nil
+}
+
+// windowFor returns the window and starting position to use for the
+// given reader, continuing on from a previous match if it was returned
+// by one of the Rest methods.
+func windowFor(r io.Reader) (*inputWindow, int) {
+	if wr, isWindowReader := r.(*windowReader); isWindowReader {
+		return wr.w, wr.pos
+	}
+	return newInputWindow(r), 0
+}
+
+// doExecuteWindow is doExecute for an inputWindow. Positions in the
+// result are positions in the window. If the search goes over re's
+// limits, there is no match, and the window holds the error.
+func (re *Regexp) doExecuteWindow(w *inputWindow, pos int, ncap int, dstCap []int) []int {
+	w.chunked = re.eotMode == EOTChunk
+	w.maxSteps, w.maxMatchLen = re.limits.MaxSteps, re.limits.MaxMatchLen
+	a := re.executeWindow(w, pos, ncap, dstCap)
+	if w.overLimit {
+		return nil
+	}
+	if a != nil && w.maxMatchLen > 0 && a[1]-a[0] > w.maxMatchLen {
+		w.exceed("streamregexp: match is longer than %d bytes", w.maxMatchLen)
+		return nil
+	}
+	return a
+}
+
+// executeWindow runs the search for doExecuteWindow.
+func (re *Regexp) executeWindow(w *inputWindow, pos int, ncap int, dstCap []int) []int {
+	if dstCap == nil {
+		// Make sure 'return dstCap' is non-nil.
+		dstCap = arrayNoInts[:0:0]
+	}
+
+	// The one-pass engine and the DFA work out the empty-width
+	// assertions from the runes on either side of each position, so
+	// they cannot see the ends of the chunks. The backtracker asks the
+	// window, and the NFA checks for them.
+	idle := pos
+	if !w.chunked {
+		if re.onepass != nil {
+			m := newOnePassMachine()
+			dstCap = re.onePass(m, w, pos, ncap, dstCap)
+			freeOnePassMachine(m)
+			return dstCap
+		}
+
+		// The DFA finds out whether there is a match much faster
+		// than the NFA can, and tells the NFA where it needs to
+		// start looking.
+		var matched bool
+		matched, _, idle = re.dfaSearch(w, pos)
+		if !matched {
+			return nil
+		}
+	}
+
+	// As with in-memory inputs, the backtracker is faster than the NFA if
+	// what is left of the input is small enough for it.
+	if re.maxBitStateLen > 0 && w.holdsRest(idle, re.maxBitStateLen) {
+		b := newBitState()
+		dstCap = re.backtrackInput(b, w, idle, w.end(), idle, ncap, dstCap)
+		freeBitState(b)
+		return dstCap
+	}
+
+	m := re.get()
+	m.init(ncap)
+	if !m.match(w, idle) {
+		re.put(m)
+		return nil
+	}
+
+	dstCap = append(dstCap, m.matchcap...)
+	re.put(m)
+	return dstCap
+}
+
+// findRest runs the search for the Rest methods, returning the
+// location of the match relative to the start of r, the window, and the
+// position in the window r started at.
+func (re *Regexp) findRest(r io.Reader, ncap int) ([]int, *inputWindow, int) {
+	w, start := windowFor(r)
+	w.discard(start)
+	a := re.doExecuteWindow(w, start, ncap, nil)
+	if a == nil {
+		if w.err != nil {
+			// The search consumed the whole stream.
+			return nil, w, w.end()
+		}
+		// The search gave up early, as an anchored one may, and
+		// the stream is still all there.
+		return nil, w, start
+	}
+	w.discard(a[1])
+	return a, w, start
+}
+
+// FindReaderRest returns the text of the leftmost match of the regular
+// expression in the text read from r, a two-element slice of integers
+// defining its location as FindReaderIndex does, and an io.Reader
+// positioned immediately after the match.
+//
+// Matching may need to read past the end of the match. Anything read
+// past the match is re-supplied by rest before it continues on to read
+// from r. Any error other than io.EOF from r will be returned by rest
+// once it has returned everything read before the error.
+//
+// If there is no match, match and loc are nil. Generally the search will
+// have had to read all of r to determine that, and rest will be
+// positioned at the end of it, but if the search could give up early, as
+// an expression anchored with \A may, rest is positioned at the start of
+// r.
+//
+// If rest is passed back in to FindReaderRest or FindReaderSubmatchRest,
+// they pick up where the last match left off, and assertions like ^ and
+// \b take the text before the match into account, but loc is relative to
+// the start of rest. Note that if the match is empty, rest is positioned
+// where the match was, so calling FindReaderRest with it again will find
+// the same empty match.
+func (re *Regexp) FindReaderRest(r io.Reader) (match []byte, loc []int, rest io.Reader) {
+	a, w, start := re.findRest(r, 2)
+	if a == nil {
+		return nil, nil, &windowReader{w, start}
+	}
+	match = append([]byte{}, w.bytes(a[0], a[1])...)
+	return match, []int{a[0] - start, a[1] - start}, &windowReader{w, a[1]}
+}
+
+// FindReaderSubmatchRest is like FindReaderRest, but it returns the text
+// of the match and its subexpressions, as FindSubmatch does, and their
+// locations, as FindReaderSubmatchIndex does.
+func (re *Regexp) FindReaderSubmatchRest(r io.Reader) (submatch [][]byte, loc []int, rest io.Reader) {
+	a, w, start := re.findRest(r, re.prog.NumCap)
+	if a == nil {
+		return nil, nil, &windowReader{w, start}
+	}
+	a = re.pad(a)
+	submatch = make([][]byte, 1+re.numSubexp)
+	for i := range submatch {
+		if a[2*i] >= 0 {
+			submatch[i] = append([]byte{}, w.bytes(a[2*i], a[2*i+1])...)
+			a[2*i] -= start
+			a[2*i+1] -= start
+		}
+	}
+	return submatch, a, &windowReader{w, a[1] + start}
+}
+
+// A ReaderMatch is a match found in a stream by FindAllReader or
+// FindAllReaderSubmatch.
+type ReaderMatch struct {
+	// Submatch holds the text of the match and, for
+	// FindAllReaderSubmatch, of its subexpressions, as defined by the
+	// 'Submatch' description in the package comment. The slices refer
+	// to the window the stream is being read through, and are only
+	// valid until the next iteration.
+	Submatch [][]byte
+
+	// Index holds the byte offsets of the match and, for
+	// FindAllReaderSubmatch, of its subexpressions, as defined by the
+	// 'Submatch' and 'Index' descriptions in the package comment.
+	// Offsets are from the start of the reader.
+	Index []int
+}
+
+// FindAllReader is the 'All' version of FindReaderRest; it returns an
+// iterator over all successive matches of the expression in the text
+// read from r, as defined by the 'All' description in the package
+// comment. If n >= 0, it stops after n matches.
+//
+// Matches are found as the iterator is pulled, and the text of the
+// stream is only kept around for as long as it may be part of a match,
+// so memory use is bounded by the longest match, not by the length of
+// the stream.
+//
+// If reading r fails with an error other than io.EOF, the iterator will
+// yield that error with an empty ReaderMatch as its final value.
+func (re *Regexp) FindAllReader(r io.Reader, n int) iter.Seq2[ReaderMatch, error] {
+	return re.allReaderMatches(r, n, false)
+}
+
+// FindAllReaderSubmatch is the 'All' version of FindReaderSubmatchRest;
+// it is like FindAllReader, but it yields the matches of the
+// subexpressions as well.
+func (re *Regexp) FindAllReaderSubmatch(r io.Reader, n int) iter.Seq2[ReaderMatch, error] {
+	return re.allReaderMatches(r, n, true)
+}
+
+// allReaderMatches implements the FindAllReader family.
+func (re *Regexp) allReaderMatches(r io.Reader, n int, submatches bool) iter.Seq2[ReaderMatch, error] {
+	ncap := 2
+	if submatches {
+		ncap = re.prog.NumCap
+	}
+	return func(yield func(ReaderMatch, error) bool) {
+		w, start := windowFor(r)
+		re.windowMatches(w, start, n, ncap, func(matches []int, err error) bool {
+			if err != nil {
+				return yield(ReaderMatch{}, err)
+			}
+			if submatches {
+				matches = re.pad(matches)
+			}
+			return yield(readerMatch(w, start, matches), nil)
+		})
+	}
+}
+
+// windowMatches finds up to n successive matches in w from start,
+// calling found with the positions of each match in the window, until
+// found returns false. If reading the stream fails, found is then called
+// with the error. It follows allMatches, including the rules for empty
+// matches.
+func (re *Regexp) windowMatches(w *inputWindow, start int, n int, ncap int, found func(matches []int, err error) bool) {
+	pos, prevMatchEnd, i := start, -1, 0
+	for n < 0 || i < n {
+		w.discard(pos)
+		matches := re.doExecuteWindow(w, pos, ncap, nil)
+		if len(matches) == 0 {
+			break
+		}
+
+		accept := true
+		atEnd := false
+		if matches[1] == pos {
+			// We've found an empty match.
+			if matches[0] == prevMatchEnd {
+				// We don't allow an empty match right
+				// after a previous match, so ignore it.
+				accept = false
+			}
+			_, width := w.step(pos)
+			pos += width
+			atEnd = width == 0
+		} else {
+			pos = matches[1]
+		}
+		prevMatchEnd = matches[1]
+
+		if accept {
+			if !found(matches, nil) {
+				return
+			}
+			i++
+		}
+		if atEnd {
+			break
+		}
+	}
+
+	// If we stopped for lack of input, rather than because we had all
+	// we wanted, report why the input ran out.
+	if (n < 0 || i < n) && w.err != nil && w.err != io.EOF {
+		found(nil, w.err)
+	}
+}
+
+// readerMatch returns the ReaderMatch for the given positions in the
+// window, making them relative to start.
+func readerMatch(w *inputWindow, start int, matches []int) ReaderMatch {
+	submatch := make([][]byte, len(matches)/2)
+	for j := range submatch {
+		if matches[2*j] >= 0 {
+			submatch[j] = w.bytes(matches[2*j], matches[2*j+1])
+			matches[2*j] -= start
+			matches[2*j+1] -= start
+		}
+	}
+	return ReaderMatch{submatch, matches}
+}
+
+// SplitReader is the io.Reader version of Split; it returns an iterator
+// over the segments of the text read from r between the matches of the
+// expression. n determines the number of segments, as it does for
+// Split.
+//
+// Each segment is an io.Reader that streams the segment as the stream is
+// searched for the separator after it, so neither the segments nor the
+// stream are ever held in memory in full. A segment is only valid until
+// the next iteration; anything not read from it by then is skipped.
+//
+// If reading r fails with an error other than io.EOF, the segment being
+// read at the time will return that error once it has returned what came
+// before it, and it will be the final segment.
+func (re *Regexp) SplitReader(r io.Reader, n int) iter.Seq[io.Reader] {
+	return func(yield func(io.Reader) bool) {
+		if n == 0 {
+			return
+		}
+
+		w, start := windowFor(r)
+		next, stop := iter.Pull(re.splitEvents(w, start))
+		defer stop()
+
+		// As in Split, beg is where the current segment begins, and
+		// end is where the last separator used began.
+		beg, end, segments := start, start, 0
+		for {
+			if n > 0 && segments == n-1 {
+				stop()
+				if re.splitTailExists(w, start, beg, end) {
+					yield(&windowReader{w, beg})
+				}
+				return
+			}
+
+			seg := &splitSegment{w: w, next: next}
+			event, _ := next()
+			switch {
+			case event.eof:
+				if re.splitTailExists(w, start, beg, end) {
+					yield(&windowReader{w, beg})
+				}
+				return
+			case event.sep != nil:
+				if event.sep[1] == start {
+					// An empty separator at the very start
+					// does not make an empty segment.
+					beg, end = event.sep[1], event.sep[0]
+					continue
+				}
+				seg.sep = event.sep
+			default:
+				seg.pending = event.data
+			}
+
+			if !yield(seg) {
+				return
+			}
+			for seg.sep == nil && seg.rest == nil {
+				seg.advance()
+			}
+			if seg.rest != nil {
+				return
+			}
+			seg.pending = nil
+			beg, end = seg.sep[1], seg.sep[0]
+			segments++
+		}
+	}
+}
+
+// splitTailExists decides whether SplitReader should yield the segment
+// after the last separator, which starts at beg, following Split.
+func (re *Regexp) splitTailExists(w *inputWindow, start, beg, end int) bool {
+	_, width := w.step(beg)
+	if width > 0 || end != beg || w.err != io.EOF {
+		return true
+	}
+	// Split always returns one segment for empty input, unless the
+	// expression is empty too.
+	return beg == start && len(re.expr) > 0
+}
+
+// A splitEvent is one step of the search SplitReader performs: some
+// data of the current segment, the separator at its end, or the end of
+// the separators. After the end, the rest of the stream starts at pos.
+type splitEvent struct {
+	data []byte
+	sep  []int
+	eof  bool
+	pos  int
+}
+
+// splitEvents searches w for separators, yielding the data between them
+// as the search discards it.
+func (re *Regexp) splitEvents(w *inputWindow, start int) iter.Seq[splitEvent] {
+	return func(yield func(splitEvent) bool) {
+		stopped := false
+		w.flushed = start
+		w.flush = func(data []byte) bool {
+			stopped = !yield(splitEvent{data: data})
+			return !stopped
+		}
+		defer func() { w.flush = nil }()
+
+		pos, prevMatchEnd := start, -1
+		for {
+			w.discard(pos)
+			a := re.doExecuteWindow(w, pos, 2, nil)
+			if stopped {
+				return
+			}
+			if a == nil {
+				break
+			}
+
+			// This follows allMatches, including the rules for
+			// empty matches.
+			accept := true
+			atEnd := false
+			if a[1] == pos {
+				if a[0] == prevMatchEnd {
+					accept = false
+				}
+				_, width := w.step(pos)
+				pos += width
+				atEnd = width == 0
+			} else {
+				pos = a[1]
+			}
+			prevMatchEnd = a[1]
+
+			if accept {
+				if a[0] > w.flushed {
+					if !yield(splitEvent{data: w.bytes(w.flushed, a[0])}) {
+						return
+					}
+				}
+				w.flushed = a[1]
+				if !yield(splitEvent{sep: a}) {
+					return
+				}
+			}
+			if atEnd {
+				break
+			}
+		}
+
+		// The window must not flush into this coroutine once it has
+		// handed control back for good.
+		w.flush = nil
+		yield(splitEvent{eof: true, pos: w.flushed})
+	}
+}
+
+// splitSegment is a segment yielded by SplitReader.
+type splitSegment struct {
+	w       *inputWindow
+	next    func() (splitEvent, bool)
+	pending []byte
+	// sep is the separator that ends the segment, once it is found.
+	sep []int
+	// rest is the rest of the stream, if the segment turned out to
+	// be the last one.
+	rest io.Reader
+}
+
+// advance pulls the next step of the search into the segment.
+func (s *splitSegment) advance() {
+	event, ok := s.next()
+	switch {
+	case !ok || event.eof:
+		s.rest = &windowReader{s.w, event.pos}
+	case event.sep != nil:
+		s.sep = event.sep
+	default:
+		s.pending = event.data
+	}
+}
+
+// Read implements io.Reader.
+func (s *splitSegment) Read(buf []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.rest != nil {
+			return s.rest.Read(buf)
+		}
+		if s.sep != nil {
+			return 0, io.EOF
+		}
+		s.advance()
+	}
+	n := copy(buf, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
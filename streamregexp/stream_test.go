@@ -0,0 +1,477 @@
+package streamregexp
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/thejerf/streamtools"
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+func TestFindReaderRestContinues(t *testing.T) {
+	re := MustCompile(`\bfoo\b`)
+	text := "foo foofoo xfoo foo"
+	var r io.Reader = iotest.HalfReader(strings.NewReader(text))
+
+	offset := 0
+	found := []int{}
+	for {
+		match, loc, rest := re.FindReaderRest(r)
+		if loc == nil {
+			break
+		}
+		if string(match) != "foo" {
+			t.Fatalf("unexpected match %q", match)
+		}
+		found = append(found, offset+loc[0])
+		offset += loc[1]
+		r = rest
+	}
+
+	// the foo at 4 is not followed by a boundary, and the one at 7 is
+	// only at the start of the rest because of the one at 4.
+	if len(found) != 2 || found[0] != 0 || found[1] != 16 {
+		t.Fatalf("unexpected matches at %v", found)
+	}
+}
+
+func TestFindReaderRestBoundedWindow(t *testing.T) {
+	// The window should not need to hold the whole stream to search it.
+	text := strings.Repeat("abcdefghijklmnopqrstuvwxyz", 100000) + "needle" + "hay"
+	re := MustCompile(`ne+dle|z{10}`)
+
+	_, loc, rest := re.FindReaderRest(strings.NewReader(text))
+	if loc == nil || loc[0] != len(text)-9 {
+		t.Fatalf("unexpected match %v", loc)
+	}
+	if c := cap(rest.(*windowReader).w.buf); c > 16*windowReadSize {
+		t.Fatalf("window grew to %d bytes", c)
+	}
+	remainder, _ := io.ReadAll(rest)
+	if string(remainder) != "hay" {
+		t.Fatalf("unexpected rest %q", remainder)
+	}
+}
+
+func TestFindReaderRestError(t *testing.T) {
+	failure := errors.New("failure")
+	r := io.MultiReader(
+		strings.NewReader("abc needle def"),
+		iotest.ErrReader(failure),
+	)
+
+	match, _, rest := MustCompile(`needle`).FindReaderRest(r)
+	if string(match) != "needle" {
+		t.Fatalf("unexpected match %q", match)
+	}
+	remainder, err := io.ReadAll(rest)
+	if string(remainder) != " def" || err != failure {
+		t.Fatalf("unexpected rest %q %v", remainder, err)
+	}
+}
+
+func TestFindReaderRestAnchoredFailure(t *testing.T) {
+	_, loc, rest := MustCompile(`\Afoo`).FindReaderRest(strings.NewReader("barfoo"))
+	if loc != nil {
+		t.Fatalf("unexpected match")
+	}
+	remainder, _ := io.ReadAll(rest)
+	if string(remainder) != "barfoo" {
+		t.Fatalf("unexpected rest %q", remainder)
+	}
+}
+
+func TestFindAllReaderLimits(t *testing.T) {
+	re := MustCompile(`a+`)
+	text := "xaxaaxaaax"
+
+	count := 0
+	for range re.FindAllReader(strings.NewReader(text), 2) {
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("n was not respected: %d", count)
+	}
+
+	count = 0
+	for range re.FindAllReader(strings.NewReader(text), -1) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("break was not respected")
+	}
+
+	// picking up from a rest reader reports offsets relative to it
+	_, _, rest := re.FindReaderRest(strings.NewReader(text))
+	offsets := []int{}
+	for match, _ := range re.FindAllReader(rest, -1) {
+		offsets = append(offsets, match.Index[0])
+	}
+	if len(offsets) != 2 || offsets[0] != 1 || offsets[1] != 4 {
+		t.Fatalf("unexpected offsets %v", offsets)
+	}
+}
+
+func TestFindAllReaderSubmatchEngines(t *testing.T) {
+	// The matches near the end of the stream are found with the
+	// backtracker once the window holds the rest of it, and the ones
+	// before that with the NFA.
+	filler := strings.Repeat("word ", 3*windowReadSize)
+	text := "ab@cd " + filler + "e@f, g@h." + filler[:100] + "x@yz"
+
+	for _, expr := range []string{`\b(\w+)@(\w+)\b`, `(\w)@(\w+)|(x)@`, `(?U)(\w+)@(\w+)`} {
+		re := MustCompile(expr)
+		if re.onepass != nil || re.maxBitStateLen == 0 {
+			t.Fatalf("%#q does not use the backtracker", expr)
+		}
+		want := re.FindAllStringSubmatchIndex(text, -1)
+
+		have := [][]int{}
+		for match, err := range re.FindAllReaderSubmatch(iotest.HalfReader(strings.NewReader(text)), -1) {
+			if err != nil {
+				t.Fatalf("%#q: unexpected error: %v", expr, err)
+			}
+			have = append(have, match.Index)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%#q: FindAllReaderSubmatch found %v, want %v", expr, have, want)
+		}
+	}
+}
+
+func TestFindAllReaderError(t *testing.T) {
+	failure := errors.New("failure")
+	r := io.MultiReader(
+		strings.NewReader("a b a"),
+		iotest.ErrReader(failure),
+	)
+
+	matches := 0
+	var lastErr error
+	for match, err := range MustCompile(`a`).FindAllReader(r, -1) {
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if lastErr != nil {
+			t.Fatalf("match after the error")
+		}
+		if string(match.Submatch[0]) != "a" {
+			t.Fatalf("unexpected match %q", match.Submatch[0])
+		}
+		matches++
+	}
+	if matches != 2 || lastErr != failure {
+		t.Fatalf("unexpected results: %d %v", matches, lastErr)
+	}
+}
+
+func TestFindAllReaderBoundedWindow(t *testing.T) {
+	// many small matches in a big stream should not grow the window
+	chunk := strings.Repeat("x", 1000) + "<tag>"
+	text := strings.Repeat(chunk, 2000)
+	w := newInputWindow(strings.NewReader(text))
+	re := MustCompile(`<[a-z]+>`)
+
+	matches := 0
+	for match, err := range re.FindAllReader(&windowReader{w, 0}, -1) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if match.Index[0] != matches*len(chunk)+1000 {
+			t.Fatalf("unexpected match at %d", match.Index[0])
+		}
+		matches++
+	}
+	if matches != 2000 {
+		t.Fatalf("unexpected match count %d", matches)
+	}
+	if cap(w.buf) > 16*windowReadSize {
+		t.Fatalf("window grew to %d bytes", cap(w.buf))
+	}
+}
+
+func TestSplitReaderStreams(t *testing.T) {
+	// segments much bigger than the window should stream through it
+	record := strings.Repeat("abcdefghij", 10000)
+	text := strings.Repeat(record+"\n--\n", 20) + record
+	w := newInputWindow(strings.NewReader(text))
+	re := MustCompile(`\n-+\n`)
+
+	segments := 0
+	for segment := range re.SplitReader(&windowReader{w, 0}, -1) {
+		// skip every other segment, reading only part of it
+		if segments%2 == 1 {
+			buf := make([]byte, 10)
+			n, _ := io.ReadFull(segment, buf)
+			if string(buf[:n]) != "abcdefghij" {
+				t.Fatalf("unexpected start of segment %q", buf[:n])
+			}
+			segments++
+			continue
+		}
+		b, err := io.ReadAll(segment)
+		if err != nil || string(b) != record {
+			t.Fatalf("segment %d: unexpected %d bytes, %v", segments, len(b), err)
+		}
+		segments++
+	}
+	if segments != 21 {
+		t.Fatalf("unexpected segment count %d", segments)
+	}
+	if cap(w.buf) > 16*windowReadSize {
+		t.Fatalf("window grew to %d bytes", cap(w.buf))
+	}
+}
+
+func TestSplitReaderBreak(t *testing.T) {
+	re := MustCompile(`,`)
+	r := strings.NewReader("a,bcd,e")
+	for segment := range re.SplitReader(r, -1) {
+		buf := make([]byte, 1)
+		_, _ = segment.Read(buf)
+		break
+	}
+
+	// The tail is the rest of the stream, and can be searched further.
+	for segment := range re.SplitReader(strings.NewReader("a,bcd,e,f"), 2) {
+		match, loc, rest := re.FindReaderRest(segment)
+		if loc == nil {
+			continue
+		}
+		remainder, _ := io.ReadAll(rest)
+		if string(match) != "," || string(remainder) != "e,f" {
+			t.Fatalf("unexpected match %q / remainder %q", match, remainder)
+		}
+	}
+}
+
+func TestSplitReaderError(t *testing.T) {
+	failure := errors.New("failure")
+	r := io.MultiReader(
+		strings.NewReader("a,b,c"),
+		iotest.ErrReader(failure),
+	)
+
+	segments := []string{}
+	var lastErr error
+	for segment := range MustCompile(`,`).SplitReader(r, -1) {
+		b, err := io.ReadAll(segment)
+		segments = append(segments, string(b))
+		lastErr = err
+	}
+	if len(segments) != 3 || segments[2] != "c" || lastErr != failure {
+		t.Fatalf("unexpected results: %q %v", segments, lastErr)
+	}
+}
+
+// chunkSplits returns the ways of splitting text into up to three chunks,
+// along with splitting it into single bytes.
+func chunkSplits(text string) [][]string {
+	splits := [][]string{}
+	for i := 0; i <= len(text); i++ {
+		for j := i; j <= len(text); j++ {
+			splits = append(splits, []string{text[:i], text[i:j], text[j:]})
+		}
+	}
+	bytes := []string{}
+	for i := range len(text) {
+		bytes = append(bytes, text[i:i+1])
+	}
+	return append(splits, bytes)
+}
+
+func TestEOTStream(t *testing.T) {
+	// However the stream is split into chunks, the assertions see the
+	// whole of it.
+	text := "foo bar\nfoobar baz"
+	for _, expr := range []string{
+		`foo$`, `baz$`, `baz\z`, `(?m)bar$`, `foo\b`, `o\B`, `\bbar`,
+		`\b`, `$`, `^foo$`, `(?m)(\w+)$`, `(foo|bar)\b`,
+	} {
+		re := MustCompile(expr)
+		want := re.FindAllStringIndex(text, -1)
+		for _, chunks := range chunkSplits(text) {
+			have := [][]int{}
+			for match, err := range re.FindAllReader(streamtest.NewChunkReader(chunks...), -1) {
+				if err != nil {
+					t.Fatalf("%#q on %q: unexpected error: %v", expr, chunks, err)
+				}
+				have = append(have, match.Index)
+			}
+			if !reflect.DeepEqual(have, want) && len(want)+len(have) > 0 {
+				t.Fatalf("%#q on %q: found %v, want %v", expr, chunks, have, want)
+			}
+		}
+	}
+}
+
+func TestEOTChunk(t *testing.T) {
+	chunks := []string{"foo", " foo", "bar", "\n"}
+	// Padding the stream out makes it too long for the backtracker.
+	padding := []string{}
+	for range 50 {
+		padding = append(padding, strings.Repeat("#", 1000))
+	}
+
+	for _, test := range []struct {
+		expr    string
+		stream  [][]int
+		chunked [][]int
+	}{
+		{`foo$`, nil, [][]int{{0, 3}, {4, 7}}},
+		{`foo\z`, nil, [][]int{{0, 3}, {4, 7}}},
+		{`foo\b`, [][]int{{0, 3}}, [][]int{{0, 3}, {4, 7}}},
+		{`(?m)\w+$`, [][]int{{4, 10}}, [][]int{{0, 3}, {4, 10}}},
+		{`(?m)\w+?$`, [][]int{{4, 10}}, [][]int{{0, 3}, {4, 7}, {7, 10}}},
+		{`o\B`, [][]int{{1, 2}, {5, 6}, {6, 7}}, [][]int{{1, 2}, {5, 6}}},
+		{`foo bar`, nil, nil},
+		{`o fo+`, [][]int{{2, 7}}, [][]int{{2, 7}}},
+		{`^foo$`, nil, [][]int{{0, 3}}},
+	} {
+		re := MustCompile(test.expr)
+		for _, mode := range []EOTMode{EOTStream, EOTChunk} {
+			re.SetEOTMode(mode)
+			for _, padded := range []bool{false, true} {
+				want := test.stream
+				if mode == EOTChunk {
+					want = test.chunked
+				}
+				stream := chunks
+				if padded {
+					if strings.HasPrefix(test.expr, "^") {
+						continue
+					}
+					stream = append(slices.Clone(padding), chunks...)
+					offset := len(padding) * len(padding[0])
+					shifted := [][]int(nil)
+					for _, loc := range want {
+						shifted = append(shifted, []int{loc[0] + offset, loc[1] + offset})
+					}
+					want = shifted
+				}
+
+				var have [][]int
+				for match, err := range re.FindAllReader(streamtest.NewChunkReader(stream...), -1) {
+					if err != nil {
+						t.Fatalf("%#q: unexpected error: %v", test.expr, err)
+					}
+					have = append(have, match.Index)
+				}
+				if !reflect.DeepEqual(have, want) {
+					t.Errorf("%#q in mode %d, padded %v: found %v, want %v", test.expr, mode, padded, have, want)
+				}
+
+				_, loc, _ := re.FindReaderRest(streamtest.NewChunkReader(stream...))
+				if (loc == nil) != (want == nil) || loc != nil && !reflect.DeepEqual(loc, want[0]) {
+					t.Errorf("%#q in mode %d, padded %v: FindReaderRest found %v, want %v", test.expr, mode, padded, loc, want)
+				}
+			}
+		}
+	}
+}
+
+// isLimitError reports whether err is a StreamError for going over a
+// limit.
+func isLimitError(err error) bool {
+	var se streamtools.StreamError
+	return errors.As(err, &se) && se.ErrorType == streamtools.ErrLimitExceeded
+}
+
+func TestStreamLimitsSteps(t *testing.T) {
+	text := strings.Repeat("abcdefghij", 10000) + "needle"
+	for _, expr := range []string{`ne+dle`, `[n-o]e+dle`, `(?i)needle|haystack`, `(\w)(e+)dle\b`} {
+		re := MustCompile(expr)
+
+		re.SetLimits(StreamLimits{MaxSteps: 5000})
+		var last error
+		found := 0
+		for _, err := range re.FindAllReader(strings.NewReader(text), -1) {
+			if err != nil {
+				last = err
+				continue
+			}
+			found++
+		}
+		if found != 0 || !isLimitError(last) {
+			t.Errorf("%#q: found %d matches and %v", expr, found, last)
+		}
+
+		_, loc, rest := re.FindReaderRest(strings.NewReader(text))
+		if _, err := io.ReadAll(rest); loc != nil || !isLimitError(err) {
+			t.Errorf("%#q: FindReaderRest found %v, and rest returned %v", expr, loc, err)
+		}
+
+		re.SetLimits(StreamLimits{MaxSteps: 1000000})
+		found = 0
+		for _, err := range re.FindAllReader(strings.NewReader(text), -1) {
+			if err != nil {
+				t.Fatalf("%#q: unexpected error: %v", expr, err)
+			}
+			found++
+		}
+		if found != 1 {
+			t.Errorf("%#q: found %d matches", expr, found)
+		}
+	}
+}
+
+func TestStreamLimitsMatchLen(t *testing.T) {
+	re := MustCompile(`<[^>]*>`)
+	re.SetLimits(StreamLimits{MaxMatchLen: 10})
+
+	// a match that is too long is an error, even if it is not long
+	// enough to need the window to grow
+	matches := []string{}
+	var last error
+	for match, err := range re.FindAllReader(strings.NewReader("<a> <bcd> <efghijklmnop> <q>"), -1) {
+		if err != nil {
+			last = err
+			continue
+		}
+		matches = append(matches, string(match.Submatch[0]))
+	}
+	if !reflect.DeepEqual(matches, []string{"<a>", "<bcd>"}) || !isLimitError(last) {
+		t.Fatalf("found %q and %v", matches, last)
+	}
+
+	// a match that never ends must not hold the whole stream
+	r := io.MultiReader(
+		strings.NewReader("<a> <"),
+		strings.NewReader(strings.Repeat("x", 1<<20)),
+		iotest.ErrReader(errors.New("should not be read")),
+	)
+	w := newInputWindow(r)
+	matches = []string{}
+	last = nil
+	for match, err := range re.FindAllReader(&windowReader{w, 0}, -1) {
+		if err != nil {
+			last = err
+			continue
+		}
+		matches = append(matches, string(match.Submatch[0]))
+	}
+	if !reflect.DeepEqual(matches, []string{"<a>"}) || !isLimitError(last) {
+		t.Fatalf("found %q and %v", matches, last)
+	}
+	if c := cap(w.buf); c > 4*windowReadSize {
+		t.Fatalf("window grew to %d bytes", c)
+	}
+
+	re.SetLimits(StreamLimits{MaxMatchLen: 10, MaxSteps: 1000})
+	var segments []string
+	for segment := range re.SplitReader(strings.NewReader("a<b>c<"+strings.Repeat("d", 2000)), -1) {
+		b, err := io.ReadAll(segment)
+		segments = append(segments, string(b))
+		last = err
+	}
+	if len(segments) != 2 || segments[0] != "a" || !isLimitError(last) {
+		t.Fatalf("split into %d segments, the last error %v", len(segments), last)
+	}
+}
@@ -0,0 +1,13 @@
+package streamtools
+
+// Strictness determines what a reader does when its input violates its
+// preconditions. See the README for a discussion.
+type Strictness int
+
+const (
+	// Strict causes precondition violations to result in an error.
+	Strict = Strictness(iota)
+
+	// Lax causes the reader to do its best to continue on.
+	Lax
+)
@@ -1,6 +1,7 @@
 package streamtools
 
 import (
+	"io"
 	"reflect"
 )
 
@@ -102,3 +103,20 @@ func TagAs(tag Tag, target any) bool {
 type TaggedReader interface {
 	Read([]byte) (int, Tag, error)
 }
+
+// Untagged returns an io.Reader that reads from the given TaggedReader,
+// discarding all tags. This is useful for handing a TaggedReader to code
+// that only cares about the bytes, such as io.Copy, while still getting
+// any errors the TaggedReader may produce.
+func Untagged(tr TaggedReader) io.Reader {
+	return untagged{tr}
+}
+
+type untagged struct {
+	tr TaggedReader
+}
+
+func (u untagged) Read(buf []byte) (int, error) {
+	n, _, err := u.tr.Read(buf)
+	return n, err
+}
@@ -0,0 +1,189 @@
+package streamtools
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+const utf8ReaderBufSize = 4096
+
+var replacementChar = []byte(string(utf8.RuneError))
+
+// UTF8Reader validates that a stream is UTF-8 as it passes through.
+//
+// With Strict strictness, encountering invalid UTF-8 will cause a
+// StreamError with ErrInvalidUTF8 to be returned, after all the valid
+// bytes before it have been returned. The error message contains the byte
+// offset of the invalid byte in the source stream, which is also available
+// from Offset.
+//
+// With Lax strictness, each invalid byte is replaced by the UTF-8 encoding
+// of U+FFFD, the same way utf8.DecodeRune and streamregexp interpret
+// invalid bytes. Note this means the output may be longer than the input.
+//
+// As long as the buffer passed to Read is at least utf8.UTFMax bytes
+// long, Read will never split a rune across Read calls. Smaller buffers
+// will receive runes in pieces.
+//
+// UTF8Reader also implements io.RuneReader, so it can be passed directly
+// to streamregexp's MatchReader and friends. The sizes returned by ReadRune
+// are the number of bytes consumed from the source, so a replaced invalid
+// byte has a size of 1, just as with bufio.Reader. Mixing ReadRune with
+// Read calls that have split a rune is not supported.
+type UTF8Reader struct {
+	r          io.Reader
+	strictness Strictness
+
+	// raw is the bytes read from r that have not yet been validated.
+	// It is always a window into rawBuf.
+	raw    []byte
+	rawBuf []byte
+	// offset is the offset of raw[0] in the source stream.
+	offset int64
+
+	// pending is the remainder of a rune that did not fit into the
+	// last Read call's buffer. It points into pendingBuf.
+	pending    []byte
+	pendingBuf [utf8.UTFMax]byte
+
+	// err is the error from the underlying reader, if any.
+	err error
+	// failed is set once strict validation has failed.
+	failed error
+}
+
+// NewUTF8Reader returns a new UTF8Reader validating the given source with
+// the given strictness.
+func NewUTF8Reader(src io.Reader, strictness Strictness) *UTF8Reader {
+	rawBuf := make([]byte, utf8ReaderBufSize)
+	return &UTF8Reader{
+		r:          src,
+		strictness: strictness,
+		raw:        rawBuf[:0],
+		rawBuf:     rawBuf,
+	}
+}
+
+// fill reads more from the underlying reader into the raw buffer.
+func (ur *UTF8Reader) fill() {
+	if len(ur.raw) > 0 && &ur.raw[0] != &ur.rawBuf[0] {
+		copy(ur.rawBuf, ur.raw)
+	}
+	ur.raw = ur.rawBuf[:len(ur.raw)]
+
+	n, err := ur.r.Read(ur.rawBuf[len(ur.raw):])
+	ur.raw = ur.rawBuf[:len(ur.raw)+n]
+	ur.err = err
+}
+
+// next returns the next rune in the raw buffer, reading only if a full
+// rune is not already available and block is true. If ok is false, no
+// rune was available.
+func (ur *UTF8Reader) next(block bool) (r rune, size int, ok bool) {
+	for !utf8.FullRune(ur.raw) && ur.err == nil {
+		if !block {
+			return 0, 0, false
+		}
+		ur.fill()
+	}
+	if len(ur.raw) == 0 {
+		return 0, 0, false
+	}
+	r, size = utf8.DecodeRune(ur.raw)
+	return r, size, true
+}
+
+func (ur *UTF8Reader) invalid() error {
+	ur.failed = errorf(ErrInvalidUTF8,
+		"streamtools: invalid UTF-8 at byte offset %d", ur.offset)
+	return ur.failed
+}
+
+// Read implements io.Reader.
+func (ur *UTF8Reader) Read(buf []byte) (int, error) {
+	n := copy(buf, ur.pending)
+	ur.pending = ur.pending[n:]
+
+	for n < len(buf) {
+		if ur.failed != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, ur.failed
+		}
+
+		// Once we have something to return, don't block on the
+		// underlying reader to get more.
+		r, size, ok := ur.next(n == 0)
+		if !ok {
+			if n > 0 || ur.err == nil {
+				return n, nil
+			}
+			return 0, ur.err
+		}
+
+		out := ur.raw[:size]
+		if r == utf8.RuneError && size == 1 {
+			if ur.strictness == Strict {
+				if n > 0 {
+					// return the valid bytes first; the
+					// error comes on the next call.
+					_ = ur.invalid()
+					return n, nil
+				}
+				return 0, ur.invalid()
+			}
+			out = replacementChar
+		}
+
+		if len(out) > len(buf)-n {
+			if n > 0 {
+				return n, nil
+			}
+			// The buffer is too small to hold the rune at all,
+			// so it has to be split.
+			copied := copy(buf, out)
+			ur.pending = ur.pendingBuf[:copy(ur.pendingBuf[:], out[copied:])]
+			n = copied
+		} else {
+			n += copy(buf[n:], out)
+		}
+		ur.raw = ur.raw[size:]
+		ur.offset += int64(size)
+	}
+
+	return n, nil
+}
+
+// ReadRune implements io.RuneReader.
+func (ur *UTF8Reader) ReadRune() (rune, int, error) {
+	if ur.failed != nil {
+		return 0, 0, ur.failed
+	}
+	r, size, ok := ur.next(true)
+	if !ok {
+		return 0, 0, ur.err
+	}
+	if r == utf8.RuneError && size == 1 && ur.strictness == Strict {
+		return 0, 0, ur.invalid()
+	}
+	ur.raw = ur.raw[size:]
+	ur.offset += int64(size)
+	return r, size, nil
+}
+
+// Offset returns the number of bytes of the source stream that have been
+// validated. If validation has failed, this is the offset of the invalid
+// byte.
+func (ur *UTF8Reader) Offset() int64 {
+	return ur.offset
+}
+
+// Close will close the underlying reader if it is an io.Closer.
+func (ur *UTF8Reader) Close() error {
+	closer, isCloser := ur.r.(io.Closer)
+	if isCloser {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package streamtools
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/thejerf/streamtools/streamtest"
+)
+
+type utf8Test struct {
+	In      []string
+	BufSize int
+	Out     []string
+	// the offset of the invalid byte in strict mode, or -1 if valid
+	InvalidAt int64
+	// the concatenation of the output in lax mode
+	Lax string
+}
+
+func TestUTF8Reader(t *testing.T) {
+	for idx, test := range []utf8Test{
+		{
+			[]string{"abc", "def"},
+			16,
+			[]string{"abc", "def"},
+			-1,
+			"abcdef",
+		},
+		// "日" is E6 97 A5; split it across reads in every way
+		{
+			[]string{"a\xe6", "\x97\xa5b"},
+			16,
+			[]string{"a", "日b"},
+			-1,
+			"a日b",
+		},
+		{
+			[]string{"a\xe6\x97", "\xa5b"},
+			16,
+			[]string{"a", "日b"},
+			-1,
+			"a日b",
+		},
+		{
+			[]string{"\xe6", "\x97", "\xa5"},
+			16,
+			[]string{"日"},
+			-1,
+			"日",
+		},
+		// a buffer with room for "a" but not the rune after it
+		{
+			[]string{"a日b"},
+			3,
+			[]string{"a", "日", "b"},
+			-1,
+			"a日b",
+		},
+		// a buffer too small for the rune at all
+		{
+			[]string{"日"},
+			2,
+			[]string{"\xe6\x97", "\xa5"},
+			-1,
+			"日",
+		},
+		{
+			[]string{"ab\xffcd"},
+			16,
+			[]string{"ab"},
+			2,
+			"ab�cd",
+		},
+		// truncated rune at the end of the stream
+		{
+			[]string{"ab", "\xe6\x97"},
+			16,
+			[]string{"ab"},
+			2,
+			"ab��",
+		},
+	} {
+		src := streamtest.NewChunkReader(test.In...)
+		ur := NewUTF8Reader(src, Strict)
+		out := []string{}
+		var err error
+		for {
+			buf := make([]byte, test.BufSize)
+			var n int
+			n, err = ur.Read(buf)
+			if n > 0 {
+				out = append(out, string(buf[:n]))
+			}
+			if err != nil {
+				break
+			}
+		}
+		if !reflect.DeepEqual(out, test.Out) {
+			t.Fatalf("%d: strict output %q, expected %q", idx, out, test.Out)
+		}
+		if test.InvalidAt == -1 {
+			if err != io.EOF {
+				t.Fatalf("%d: unexpected error: %v", idx, err)
+			}
+		} else {
+			var se StreamError
+			if !errors.As(err, &se) || se.ErrorType != ErrInvalidUTF8 {
+				t.Fatalf("%d: expected invalid UTF-8 error, got %v",
+					idx, err)
+			}
+			if ur.Offset() != test.InvalidAt {
+				t.Fatalf("%d: invalid UTF-8 reported at %d",
+					idx, ur.Offset())
+			}
+		}
+
+		src = streamtest.NewChunkReader(test.In...)
+		lax, err := io.ReadAll(NewUTF8Reader(src, Lax))
+		if err != nil {
+			t.Fatalf("%d: unexpected lax error: %v", idx, err)
+		}
+		if string(lax) != test.Lax {
+			t.Fatalf("%d: lax output %q, expected %q", idx, lax, test.Lax)
+		}
+	}
+}
+
+func TestUTF8ReaderReadRune(t *testing.T) {
+	src := streamtest.NewChunkReader("a\xe6", "\x97\xa5\xff", "b")
+	ur := NewUTF8Reader(src, Lax)
+
+	type rs struct {
+		r    rune
+		size int
+	}
+	got := []rs{}
+	for {
+		r, size, err := ur.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, rs{r, size})
+	}
+	expected := []rs{{'a', 1}, {'日', 3}, {utf8.RuneError, 1}, {'b', 1}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected runes: %v", got)
+	}
+
+	src = streamtest.NewChunkReader("a\xff")
+	ur = NewUTF8Reader(src, Strict)
+	_, _, _ = ur.ReadRune()
+	_, _, err := ur.ReadRune()
+	var se StreamError
+	if !errors.As(err, &se) || se.ErrorType != ErrInvalidUTF8 {
+		t.Fatalf("expected invalid UTF-8 error, got %v", err)
+	}
+}